@@ -0,0 +1,43 @@
+// Command diagnostics is the diagnostics agent next to the flannel-server,
+// see package pkg/diagnostics.
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+
+	"github.com/op/go-logging"
+
+	"github.com/StephenKing/flannel-operator/pkg/diagnostics"
+)
+
+var (
+	log = logging.MustGetLogger("cmd")
+
+	listenAddress string
+)
+
+func init() {
+	flag.StringVar(&listenAddress, "listen-address", ":8891", "The address the diagnostics are served on.")
+}
+
+func main() {
+	flag.Parse()
+
+	// The node name is passed in from spec.nodeName.
+	node := os.Getenv("NODE_NAME")
+	if node == "" {
+		log.Error("NODE_NAME is not set")
+		os.Exit(1)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(diagnostics.Path, diagnostics.Handler(node))
+
+	log.Notice("Serving diagnostics of node", node, "on", listenAddress)
+	if err := http.ListenAndServe(listenAddress, mux); err != nil {
+		log.Error("Serving diagnostics failed:", err)
+		os.Exit(1)
+	}
+}
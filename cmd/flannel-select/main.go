@@ -0,0 +1,11 @@
+// Command flannel-select is the CNI plugin the operator installs on the
+// nodes, see package pkg/cniselect.
+package main
+
+import (
+	"github.com/StephenKing/flannel-operator/pkg/cniselect"
+)
+
+func main() {
+	cniselect.Main()
+}
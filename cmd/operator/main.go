@@ -1,43 +1,120 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"github.com/op/go-logging"
 	"golang.org/x/sync/errgroup"
 
+	"k8s.io/client-go/1.5/pkg/util/yaml"
 	"k8s.io/client-go/1.5/rest"
 
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1alpha1"
 	"github.com/StephenKing/flannel-operator/pkg/flannel"
 )
 
 var (
 	log = logging.MustGetLogger("cmd")
+
+	listenAddress  string
+	etcdEndpoints  string
+	serverRequests string
+	serverLimits   string
+	clientRequests string
+	clientLimits   string
+	overridesFile  string
+	uninstall      bool
+	assumeYes      bool
+	cfg            flannel.Config
 )
 
+func init() {
+	flag.StringVar(&listenAddress, "listen-address", ":8080", "The address the operator's HTTP endpoints are served on.")
+	flag.BoolVar(&cfg.IPMasq, "ip-masq", true, "Masquerade traffic leaving FlannelNetworks that don't set spec.ipMasq.")
+	flag.IntVar(&cfg.MTU, "mtu", 0, "MTU of the pod interfaces of FlannelNetworks that don't set spec.mtu (default 1450).")
+	flag.StringVar(&cfg.Datastore, "datastore", "etcd", "Where flannel keeps network configs and subnet leases: etcd or kubernetes.")
+	flag.StringVar(&etcdEndpoints, "etcd-endpoints", "", "Comma separated etcd endpoints of the flannel-server (default http://<node>:2379).")
+	flag.StringVar(&cfg.EtcdTLSSecret, "etcd-tls-secret", "", "Secret in kube-system with ca.crt, tls.crt and tls.key for etcd.")
+	flag.StringVar(&cfg.EtcdPrefix, "etcd-prefix", "", "etcd key prefix of the flannel network configs (default /coreos.com/network).")
+	flag.BoolVar(&cfg.RemoteTLS, "remote-tls", false, "Secure the connections between flannel clients and the flannel-server with TLS.")
+	flag.StringVar(&cfg.FlannelVersion, "flannel-version", "", "Flannel release of the flannel-server and of clients that don't set spec.flannelVersion (default v0.6.2).")
+	flag.StringVar(&serverRequests, "server-requests", "", "Resource requests of the flannel-server, e.g. cpu=100m,memory=50Mi.")
+	flag.StringVar(&serverLimits, "server-limits", "cpu=200m", "Resource limits of the flannel-server.")
+	flag.StringVar(&cfg.ServerResources.QOSClass, "server-qos", "", "QoS class of the flannel-server pods: Guaranteed, Burstable or BestEffort.")
+	flag.StringVar(&clientRequests, "client-requests", "", "Resource requests of the flannel clients of FlannelNetworks that don't set spec.resources.")
+	flag.StringVar(&clientLimits, "client-limits", "", "Resource limits of the flannel clients of FlannelNetworks that don't set spec.resources.")
+	flag.StringVar(&cfg.ClientResources.QOSClass, "client-qos", "", "QoS class of the flannel client pods of FlannelNetworks that don't set spec.resources.")
+	flag.StringVar(&overridesFile, "pod-template-overrides", "", "YAML or JSON file with overrides merged onto the pod templates of all flannel pods.")
+	flag.StringVar(&cfg.CNIPluginImage, "cni-plugin-image", "stephenking/flannel-operator", "Image the flannel-select CNI plugin is installed from, usually the operator's own.")
+	flag.StringVar(&cfg.DiagnosticsImage, "diagnostics-image", "", "Image with the diagnostics agent run next to the flannel-server, e.g. the operator's own. Disabled if empty.")
+	flag.StringVar(&cfg.WebhookListenAddress, "webhook-listen-address", "", "The address the admission webhooks are served on with TLS, e.g. :8443. Disabled if empty.")
+	flag.StringVar(&cfg.WebhookService, "webhook-service", "flannel-operator", "Name of the Service in front of the admission webhooks.")
+	flag.StringVar(&cfg.WebhookNamespace, "webhook-namespace", "kube-system", "Namespace of the Service in front of the admission webhooks.")
+	flag.BoolVar(&cfg.DryRun, "dry-run", false, "Only log and record the changes the operator would make, served as JSON on /plan.")
+	flag.BoolVar(&uninstall, "uninstall", false, "Remove all FlannelNetworks and everything the operator created, then exit.")
+	flag.BoolVar(&assumeYes, "yes", false, "Don't ask for confirmation on -uninstall.")
+}
+
 func Main() int {
+	if etcdEndpoints != "" {
+		cfg.EtcdEndpoints = strings.Split(etcdEndpoints, ",")
+	}
+	cfg.ServerResources.Requests = resourceList(serverRequests)
+	cfg.ServerResources.Limits = resourceList(serverLimits)
+	cfg.ClientResources.Requests = resourceList(clientRequests)
+	cfg.ClientResources.Limits = resourceList(clientLimits)
+
 	// For now always use the built in service account.
-	cfg, err := rest.InClusterConfig()
+	restCfg, err := rest.InClusterConfig()
 	if err != nil {
 		log.Errorf("Error getting Kubernetes config: %v", err)
 		return 1
 	}
 
-	po, err := flannel.New(cfg)
+	if overridesFile != "" {
+		if cfg.PodTemplateOverrides, err = readPodTemplateOverrides(overridesFile); err != nil {
+			log.Errorf("Error reading pod template overrides: %v", err)
+			return 1
+		}
+	}
+
+	po, err := flannel.New(restCfg, cfg)
 	if err != nil {
 		log.Errorf("Failed to create flannel operator: %v", err)
 		return 1
 	}
 
+	if uninstall {
+		return Uninstall(po)
+	}
+
+	mux := http.NewServeMux()
+	po.RegisterHandlers(mux)
+
+	l, err := net.Listen("tcp", listenAddress)
+	if err != nil {
+		log.Errorf("Listening on %s failed: %v", listenAddress, err)
+		return 1
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	wg, ctx := errgroup.WithContext(ctx)
 
 	wg.Go(func() error { return po.Run(ctx.Done()) })
 
-	term := make(chan os.Signal)
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(l)
+
+	term := make(chan os.Signal, 1)
 	signal.Notify(term, os.Interrupt, syscall.SIGTERM)
 
 	select {
@@ -55,6 +132,57 @@ func Main() int {
 	return 0
 }
 
+// resourceList splits a list like cpu=100m,memory=50Mi. The quantities are
+// checked when the operator is created.
+func resourceList(s string) map[string]string {
+	if s == "" {
+		return nil
+	}
+	list := map[string]string{}
+	for _, kv := range strings.Split(s, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 1 {
+			parts = append(parts, "")
+		}
+		list[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return list
+}
+
+func readPodTemplateOverrides(path string) (*v1alpha1.PodTemplateOverrides, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var overrides v1alpha1.PodTemplateOverrides
+	if err := yaml.NewYAMLOrJSONDecoder(f, 4096).Decode(&overrides); err != nil {
+		return nil, fmt.Errorf("decode %s: %s", path, err)
+	}
+	return &overrides, nil
+}
+
+// Uninstall tears down the operator's objects after asking for confirmation
+// on stdin, unless -yes is given.
+func Uninstall(po *flannel.Operator) int {
+	if !assumeYes && !cfg.DryRun {
+		fmt.Print("This deletes all FlannelNetworks, their flannel clients and the flannel-server. Type \"uninstall\" to continue: ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.TrimSpace(answer) != "uninstall" {
+			log.Notice("Uninstall aborted")
+			return 1
+		}
+	}
+
+	if err := po.Uninstall(); err != nil {
+		log.Errorf("Uninstall failed: %v", err)
+		return 1
+	}
+	return 0
+}
+
 func main() {
+	flag.Parse()
 	os.Exit(Main())
 }
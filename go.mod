@@ -0,0 +1,50 @@
+module github.com/StephenKing/flannel-operator
+
+go 1.21
+
+require (
+	github.com/containernetworking/cni v0.6.0
+	github.com/coreos/etcd v3.2.9+incompatible
+	github.com/op/go-logging v0.0.0-20160315200505-970db520ece7
+	golang.org/x/net v0.0.0-20160715184138-e90d6d0afc4c
+	golang.org/x/sync v0.1.0
+	k8s.io/client-go v1.5.1
+)
+
+require (
+	cloud.google.com/go v0.1.1-0.20160913182117-3b1ae45394a2 // indirect
+	github.com/PuerkitoBio/purell v1.0.0 // indirect
+	github.com/PuerkitoBio/urlesc v0.0.0-20160726150825-5bd2802263f2 // indirect
+	github.com/blang/semver v3.0.1+incompatible // indirect
+	github.com/coreos/go-oidc v0.0.0-20160818215358-5644a2f50e2d // indirect
+	github.com/coreos/go-semver v0.2.0 // indirect
+	github.com/coreos/pkg v0.0.0-20160620232715-fa29b1d70f0b // indirect
+	github.com/davecgh/go-spew v0.0.0-20151105211317-5215b55f46b2 // indirect
+	github.com/docker/distribution v2.4.1+incompatible // indirect
+	github.com/emicklei/go-restful v1.1.4-0.20160814184150-89ef8af493ab // indirect
+	github.com/ghodss/yaml v0.0.0-20150909031657-73d445a93680 // indirect
+	github.com/go-openapi/jsonpointer v0.0.0-20160704185906-46af16f9f7b1 // indirect
+	github.com/go-openapi/jsonreference v0.0.0-20160704190145-13c6e3589ad9 // indirect
+	github.com/go-openapi/spec v0.0.0-20160808142527-6aced65f8501 // indirect
+	github.com/go-openapi/swag v0.0.0-20160704191624-1d0bd113de87 // indirect
+	github.com/gogo/protobuf v0.0.0-20160718161353-e18d7aa8f8c6 // indirect
+	github.com/golang/glog v0.0.0-20141105023935-44145f04b68c // indirect
+	github.com/golang/groupcache v0.0.0-20160516000752-02826c3e7903 // indirect
+	github.com/golang/protobuf v0.0.0-20160608215545-8616e8ee5e20 // indirect
+	github.com/google/gofuzz v0.0.0-20150304233714-bbcb9da2d746 // indirect
+	github.com/howeyc/gopass v0.0.0-20160826175423-3ca23474a7c7 // indirect
+	github.com/imdario/mergo v0.0.0-20141206190957-6633656539c1 // indirect
+	github.com/jonboulle/clockwork v0.0.0-20141017032234-72f9bd7c4e0c // indirect
+	github.com/juju/ratelimit v0.0.0-20151125201925-77ed1c8a0121 // indirect
+	github.com/mailru/easyjson v0.0.0-20160728113105-d5b7844b561a // indirect
+	github.com/pborman/uuid v0.0.0-20150603214016-ca53cad383ca // indirect
+	github.com/spf13/pflag v0.0.0-20160718215057-1560c1005499 // indirect
+	github.com/ugorji/go v0.0.0-20151028022000-f1f1a805ed36 // indirect
+	golang.org/x/crypto v0.0.0-20160126184038-1f22c0103821 // indirect
+	golang.org/x/oauth2 v0.0.0-20160902055913-3c3a985cb79f // indirect
+	golang.org/x/sys v0.0.0-20150901164945-9c60d1c508f5 // indirect
+	golang.org/x/text v0.0.0-20160726164857-2910a502d2bf // indirect
+	google.golang.org/appengine v0.0.0-20160823001527-4f7eeb5305a4 // indirect
+	gopkg.in/inf.v0 v0.9.0 // indirect
+	gopkg.in/yaml.v2 v2.0.0-20150924142314-53feefa2559f // indirect
+)
@@ -0,0 +1,72 @@
+// Package deepcopy has the deep copies of field types shared by the
+// FlannelNetwork API versions.
+package deepcopy
+
+import (
+	"k8s.io/client-go/1.5/pkg/api/v1"
+	"k8s.io/client-go/1.5/pkg/conversion"
+)
+
+// ObjectMeta copies in into out.
+func ObjectMeta(in *v1.ObjectMeta, out *v1.ObjectMeta) {
+	*out = *in
+	if in.DeletionTimestamp != nil {
+		t := *in.DeletionTimestamp
+		out.DeletionTimestamp = &t
+	}
+	if in.DeletionGracePeriodSeconds != nil {
+		s := *in.DeletionGracePeriodSeconds
+		out.DeletionGracePeriodSeconds = &s
+	}
+	out.Labels = StringMap(in.Labels)
+	out.Annotations = StringMap(in.Annotations)
+	if in.OwnerReferences != nil {
+		out.OwnerReferences = make([]v1.OwnerReference, len(in.OwnerReferences))
+		for i, ref := range in.OwnerReferences {
+			out.OwnerReferences[i] = ref
+			if ref.Controller != nil {
+				c := *ref.Controller
+				out.OwnerReferences[i].Controller = &c
+			}
+		}
+	}
+	out.Finalizers = Strings(in.Finalizers)
+}
+
+// StringMap returns a copy of in.
+func StringMap(in map[string]string) map[string]string {
+	if in == nil {
+		return nil
+	}
+	out := make(map[string]string, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
+
+// Strings returns a copy of in.
+func Strings(in []string) []string {
+	if in == nil {
+		return nil
+	}
+	out := make([]string, len(in))
+	copy(out, in)
+	return out
+}
+
+// Containers returns a copy of in, made field by field by the generated deep
+// copy of client-go.
+func Containers(in []v1.Container) []v1.Container {
+	if in == nil {
+		return nil
+	}
+	out := make([]v1.Container, len(in))
+	cloner := conversion.NewCloner()
+	for i := range in {
+		// Only fields copied by reflection can fail, and containers have
+		// none, so the error is always nil.
+		_ = v1.DeepCopy_v1_Container(&in[i], &out[i], cloner)
+	}
+	return out
+}
@@ -1,7 +1,6 @@
 package v1alpha1
 
 import (
-	"k8s.io/client-go/1.5/dynamic"
 	"k8s.io/client-go/1.5/pkg/api"
 	"k8s.io/client-go/1.5/pkg/api/unversioned"
 	"k8s.io/client-go/1.5/pkg/runtime"
@@ -17,15 +16,25 @@ const (
 type FlannelNetworkV1alpha1Interface interface {
 	RESTClient() *rest.RESTClient
 	FlannelNetworksGetter
+	ClusterFlannelNetworksGetter
+}
+
+// NetworksGetter gives access to both kinds of networks.
+type NetworksGetter interface {
+	FlannelNetworksGetter
+	ClusterFlannelNetworksGetter
 }
 
 type FlannelNetworkV1alpha1Client struct {
-	restClient    *rest.RESTClient
-	dynamicClient *dynamic.Client
+	restClient *rest.RESTClient
 }
 
 func (c *FlannelNetworkV1alpha1Client) FlannelNetworks(namespace string) FlannelNetworkInterface {
-	return newFlannelNetworks(c.restClient, c.dynamicClient, namespace)
+	return newFlannelNetworks(c.restClient, namespace)
+}
+
+func (c *FlannelNetworkV1alpha1Client) ClusterFlannelNetworks() ClusterFlannelNetworkInterface {
+	return newClusterFlannelNetworks(c.restClient)
 }
 
 func (c *FlannelNetworkV1alpha1Client) RESTClient() *rest.RESTClient {
@@ -41,13 +50,8 @@ func NewForConfig(c *rest.Config) (*FlannelNetworkV1alpha1Client, error) {
 		return nil, err
 	}
 
-	dynamicClient, err := dynamic.NewClient(&config)
-	if err != nil {
-		return nil, err
-	}
-
 	log.Notice("Finished creating FlannelNetworkV1alpha1Client")
-	return &FlannelNetworkV1alpha1Client{client, dynamicClient}, nil
+	return &FlannelNetworkV1alpha1Client{client}, nil
 }
 
 func setConfigDefaults(config *rest.Config) {
@@ -0,0 +1,218 @@
+package v1alpha1
+
+import (
+	"encoding/json"
+
+	"k8s.io/client-go/1.5/pkg/api"
+	"k8s.io/client-go/1.5/pkg/api/v1"
+	"k8s.io/client-go/1.5/pkg/runtime"
+	"k8s.io/client-go/1.5/pkg/watch"
+	"k8s.io/client-go/1.5/rest"
+)
+
+const (
+	TPRClusterFlannelKind = "ClusterFlannelNetwork"
+	TPRClusterFlannelName = "clusterflannelnetworks"
+)
+
+type ClusterFlannelNetworksGetter interface {
+	ClusterFlannelNetworks() ClusterFlannelNetworkInterface
+}
+
+// ClusterFlannelNetworkInterface has methods to work with
+// ClusterFlannelNetwork resources.
+type ClusterFlannelNetworkInterface interface {
+	Create(*ClusterFlannelNetwork) (*ClusterFlannelNetwork, error)
+	Get(name string) (*ClusterFlannelNetwork, error)
+	Update(*ClusterFlannelNetwork) (*ClusterFlannelNetwork, error)
+	UpdateStatus(*ClusterFlannelNetwork) (*ClusterFlannelNetwork, error)
+	Delete(name string, options *v1.DeleteOptions) error
+	DeleteCollection(options *v1.DeleteOptions, listOptions api.ListOptions) error
+	List(opts api.ListOptions) (*ClusterFlannelNetworkList, error)
+	Watch(opts api.ListOptions) (watch.Interface, error)
+	Patch(name string, pt api.PatchType, data []byte, subresources ...string) (*ClusterFlannelNetwork, error)
+}
+
+// clusterflannelnetworks implements ClusterFlannelNetworkInterface the same
+// way flannelnetworks does, without a namespace.
+type clusterflannelnetworks struct {
+	restClient *rest.RESTClient
+}
+
+func newClusterFlannelNetworks(r *rest.RESTClient) *clusterflannelnetworks {
+	return &clusterflannelnetworks{restClient: r}
+}
+
+func (f *clusterflannelnetworks) Create(o *ClusterFlannelNetwork) (*ClusterFlannelNetwork, error) {
+	log.Notice("Creating ClusterFlannelNetwork", o.Name)
+
+	body, err := encodeClusterFlannelNetwork(o)
+	if err != nil {
+		return nil, err
+	}
+	return decodeClusterFlannelNetwork(f.restClient.Post().
+		Resource(TPRClusterFlannelName).
+		Body(body).
+		DoRaw())
+}
+
+func (f *clusterflannelnetworks) Get(name string) (*ClusterFlannelNetwork, error) {
+	return decodeClusterFlannelNetwork(f.restClient.Get().
+		Resource(TPRClusterFlannelName).
+		Name(name).
+		DoRaw())
+}
+
+func (f *clusterflannelnetworks) Update(o *ClusterFlannelNetwork) (*ClusterFlannelNetwork, error) {
+	body, err := encodeClusterFlannelNetwork(o)
+	if err != nil {
+		return nil, err
+	}
+	return decodeClusterFlannelNetwork(f.restClient.Put().
+		Resource(TPRClusterFlannelName).
+		Name(o.Name).
+		Body(body).
+		DoRaw())
+}
+
+// UpdateStatus replaces the whole object like Update, see
+// flannelnetworks.UpdateStatus.
+func (f *clusterflannelnetworks) UpdateStatus(o *ClusterFlannelNetwork) (*ClusterFlannelNetwork, error) {
+	return f.Update(o)
+}
+
+func (f *clusterflannelnetworks) Delete(name string, options *v1.DeleteOptions) error {
+	log.Notice("Deleting ClusterFlannelNetwork", name)
+
+	req := f.restClient.Delete().
+		Resource(TPRClusterFlannelName).
+		Name(name)
+	if options != nil {
+		body, err := encodeDeleteOptions(options)
+		if err != nil {
+			return err
+		}
+		req = req.Body(body)
+	}
+	_, err := req.DoRaw()
+	return err
+}
+
+func (f *clusterflannelnetworks) DeleteCollection(options *v1.DeleteOptions, listOptions api.ListOptions) error {
+	log.Notice("Deleting ClusterFlannelNetworks")
+
+	req := withListOptions(f.restClient.Delete().
+		Resource(TPRClusterFlannelName), listOptions)
+	if options != nil {
+		body, err := encodeDeleteOptions(options)
+		if err != nil {
+			return err
+		}
+		req = req.Body(body)
+	}
+	_, err := req.DoRaw()
+	return err
+}
+
+func (f *clusterflannelnetworks) List(opts api.ListOptions) (*ClusterFlannelNetworkList, error) {
+	b, err := withListOptions(f.restClient.Get().
+		Resource(TPRClusterFlannelName), opts).
+		DoRaw()
+	if err != nil {
+		return nil, err
+	}
+
+	var list ClusterFlannelNetworkList
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, err
+	}
+	for _, flan := range list.Items {
+		setClusterTypeMeta(flan)
+	}
+	return &list, nil
+}
+
+func (f *clusterflannelnetworks) Watch(opts api.ListOptions) (watch.Interface, error) {
+	r, err := withListOptions(f.restClient.Get().
+		Prefix("watch").
+		Resource(TPRClusterFlannelName), opts).
+		Stream()
+	if err != nil {
+		return nil, err
+	}
+	return watch.NewStreamWatcher(&clusterFlannelNetworkDecoder{
+		dec:   json.NewDecoder(r),
+		close: r.Close,
+	}), nil
+}
+
+func (f *clusterflannelnetworks) Patch(name string, pt api.PatchType, data []byte, subresources ...string) (*ClusterFlannelNetwork, error) {
+	return decodeClusterFlannelNetwork(f.restClient.Patch(pt).
+		Resource(TPRClusterFlannelName).
+		SubResource(subresources...).
+		Name(name).
+		Body(data).
+		DoRaw())
+}
+
+// AsFlannelNetwork returns a copy of the network as a FlannelNetwork with an
+// empty namespace, so it can take the same code paths.
+func (in *ClusterFlannelNetwork) AsFlannelNetwork() *FlannelNetwork {
+	out := FlannelNetwork(*in.DeepCopy())
+	out.Namespace = ""
+	return &out
+}
+
+// ClusterFlannelNetworkFrom is the inverse of AsFlannelNetwork.
+func ClusterFlannelNetworkFrom(flan *FlannelNetwork) *ClusterFlannelNetwork {
+	out := ClusterFlannelNetwork(*flan.DeepCopy())
+	out.Namespace = ""
+	setClusterTypeMeta(&out)
+	return &out
+}
+
+func setClusterTypeMeta(f *ClusterFlannelNetwork) {
+	f.TypeMeta.Kind = TPRClusterFlannelKind
+	f.TypeMeta.APIVersion = TPRGroup + "/" + TPRVersion
+}
+
+// encodeClusterFlannelNetwork works on a copy, f may come from an informer
+// cache.
+func encodeClusterFlannelNetwork(f *ClusterFlannelNetwork) ([]byte, error) {
+	f = f.DeepCopy()
+	setClusterTypeMeta(f)
+	return json.Marshal(f)
+}
+
+func decodeClusterFlannelNetwork(b []byte, err error) (*ClusterFlannelNetwork, error) {
+	if err != nil {
+		return nil, err
+	}
+	var f ClusterFlannelNetwork
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+	setClusterTypeMeta(&f)
+	return &f, nil
+}
+
+type clusterFlannelNetworkDecoder struct {
+	dec   *json.Decoder
+	close func() error
+}
+
+func (d *clusterFlannelNetworkDecoder) Close() {
+	d.close()
+}
+
+func (d *clusterFlannelNetworkDecoder) Decode() (action watch.EventType, object runtime.Object, err error) {
+	var e struct {
+		Type   watch.EventType
+		Object ClusterFlannelNetwork
+	}
+	if err := d.dec.Decode(&e); err != nil {
+		return watch.Error, nil, err
+	}
+	setClusterTypeMeta(&e.Object)
+	return e.Type, &e.Object, nil
+}
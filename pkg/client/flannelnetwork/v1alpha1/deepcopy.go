@@ -0,0 +1,199 @@
+// The deep copies are written by hand, as deepcopy-gen output doesn't build
+// against client-go 1.5. Keep them in sync with types.go.
+
+package v1alpha1
+
+import (
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/internal/deepcopy"
+
+	"k8s.io/client-go/1.5/pkg/runtime"
+)
+
+// DeepCopyInto copies the receiver into out. in must be non-nil.
+func (in *FlannelNetwork) DeepCopyInto(out *FlannelNetwork) {
+	out.TypeMeta = in.TypeMeta
+	deepcopy.ObjectMeta(&in.ObjectMeta, &out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	if in.Status != nil {
+		status := *in.Status
+		out.Status = &status
+	} else {
+		out.Status = nil
+	}
+}
+
+// DeepCopy returns a deep copy of the FlannelNetwork.
+func (in *FlannelNetwork) DeepCopy() *FlannelNetwork {
+	if in == nil {
+		return nil
+	}
+	out := new(FlannelNetwork)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject returns a deep copy as a runtime.Object.
+func (in *FlannelNetwork) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto copies the receiver into out. in must be non-nil.
+func (in *FlannelNetworkList) DeepCopyInto(out *FlannelNetworkList) {
+	out.TypeMeta = in.TypeMeta
+	out.ListMeta = in.ListMeta
+	if in.Items != nil {
+		out.Items = make([]*FlannelNetwork, len(in.Items))
+		for i := range in.Items {
+			out.Items[i] = in.Items[i].DeepCopy()
+		}
+	} else {
+		out.Items = nil
+	}
+}
+
+// DeepCopy returns a deep copy of the FlannelNetworkList.
+func (in *FlannelNetworkList) DeepCopy() *FlannelNetworkList {
+	if in == nil {
+		return nil
+	}
+	out := new(FlannelNetworkList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject returns a deep copy as a runtime.Object.
+func (in *FlannelNetworkList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto copies the receiver into out. in must be non-nil.
+func (in *ClusterFlannelNetwork) DeepCopyInto(out *ClusterFlannelNetwork) {
+	out.TypeMeta = in.TypeMeta
+	deepcopy.ObjectMeta(&in.ObjectMeta, &out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	if in.Status != nil {
+		status := *in.Status
+		out.Status = &status
+	} else {
+		out.Status = nil
+	}
+}
+
+// DeepCopy returns a deep copy of the ClusterFlannelNetwork.
+func (in *ClusterFlannelNetwork) DeepCopy() *ClusterFlannelNetwork {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterFlannelNetwork)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject returns a deep copy as a runtime.Object.
+func (in *ClusterFlannelNetwork) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto copies the receiver into out. in must be non-nil.
+func (in *ClusterFlannelNetworkList) DeepCopyInto(out *ClusterFlannelNetworkList) {
+	out.TypeMeta = in.TypeMeta
+	out.ListMeta = in.ListMeta
+	if in.Items != nil {
+		out.Items = make([]*ClusterFlannelNetwork, len(in.Items))
+		for i := range in.Items {
+			out.Items[i] = in.Items[i].DeepCopy()
+		}
+	} else {
+		out.Items = nil
+	}
+}
+
+// DeepCopy returns a deep copy of the ClusterFlannelNetworkList.
+func (in *ClusterFlannelNetworkList) DeepCopy() *ClusterFlannelNetworkList {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterFlannelNetworkList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject returns a deep copy as a runtime.Object.
+func (in *ClusterFlannelNetworkList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto copies the receiver into out. in must be non-nil.
+func (in *FlannelNetworkSpec) DeepCopyInto(out *FlannelNetworkSpec) {
+	*out = *in
+	out.Peers = deepcopy.Strings(in.Peers)
+	if in.IPMasq != nil {
+		ipMasq := *in.IPMasq
+		out.IPMasq = &ipMasq
+	}
+	out.MasqueradeExceptions = deepcopy.Strings(in.MasqueradeExceptions)
+	if in.Resources != nil {
+		out.Resources = in.Resources.DeepCopy()
+	}
+	if in.PodTemplateOverrides != nil {
+		out.PodTemplateOverrides = in.PodTemplateOverrides.DeepCopy()
+	}
+}
+
+// DeepCopy returns a deep copy of the FlannelNetworkSpec.
+func (in *FlannelNetworkSpec) DeepCopy() *FlannelNetworkSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(FlannelNetworkSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto copies the receiver into out. in must be non-nil.
+func (in *ResourceRequirements) DeepCopyInto(out *ResourceRequirements) {
+	*out = *in
+	out.Requests = deepcopy.StringMap(in.Requests)
+	out.Limits = deepcopy.StringMap(in.Limits)
+}
+
+// DeepCopy returns a deep copy of the ResourceRequirements.
+func (in *ResourceRequirements) DeepCopy() *ResourceRequirements {
+	if in == nil {
+		return nil
+	}
+	out := new(ResourceRequirements)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto copies the receiver into out. in must be non-nil.
+func (in *PodTemplateOverrides) DeepCopyInto(out *PodTemplateOverrides) {
+	*out = *in
+	out.Labels = deepcopy.StringMap(in.Labels)
+	out.Annotations = deepcopy.StringMap(in.Annotations)
+	out.ImagePullSecrets = deepcopy.Strings(in.ImagePullSecrets)
+	out.Sidecars = deepcopy.Containers(in.Sidecars)
+}
+
+// DeepCopy returns a deep copy of the PodTemplateOverrides.
+func (in *PodTemplateOverrides) DeepCopy() *PodTemplateOverrides {
+	if in == nil {
+		return nil
+	}
+	out := new(PodTemplateOverrides)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,293 @@
+// Package fake has an in-memory FlannelNetwork client for tests of code
+// consuming FlannelNetworks, e.g. the operator with
+// flannel.NewWithClients.
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1alpha1"
+
+	"k8s.io/client-go/1.5/pkg/api"
+	"k8s.io/client-go/1.5/pkg/api/errors"
+	"k8s.io/client-go/1.5/pkg/api/unversioned"
+	"k8s.io/client-go/1.5/pkg/api/v1"
+	"k8s.io/client-go/1.5/pkg/fields"
+	"k8s.io/client-go/1.5/pkg/labels"
+	"k8s.io/client-go/1.5/pkg/watch"
+)
+
+// watchQueueLength is how many events may be pending for the watchers
+// before writes block.
+const watchQueueLength = 100
+
+var groupResource = unversioned.GroupResource{
+	Group:    v1alpha1.TPRGroup,
+	Resource: v1alpha1.TPRFlannelName,
+}
+
+// Clientset keeps FlannelNetworks and ClusterFlannelNetworks in memory. It
+// implements v1alpha1.NetworksGetter. Objects are copied on the way in and
+// out, so callers can't modify the stored ones.
+type Clientset struct {
+	mtx             sync.Mutex
+	objects         map[string]*v1alpha1.FlannelNetwork
+	resourceVersion int
+	broadcaster     *watch.Broadcaster
+
+	// cluster keeps the ClusterFlannelNetworks as FlannelNetworks without
+	// a namespace, apart from the namespaced ones.
+	cluster *Clientset
+}
+
+// NewSimpleClientset returns a Clientset holding the given FlannelNetworks.
+func NewSimpleClientset(objects ...*v1alpha1.FlannelNetwork) *Clientset {
+	c := newClientset()
+	c.cluster = newClientset()
+	for _, obj := range objects {
+		if _, err := c.FlannelNetworks(obj.Namespace).Create(obj); err != nil {
+			panic(err)
+		}
+	}
+	return c
+}
+
+func newClientset() *Clientset {
+	return &Clientset{
+		objects:     map[string]*v1alpha1.FlannelNetwork{},
+		broadcaster: watch.NewBroadcaster(watchQueueLength, watch.WaitIfChannelFull),
+	}
+}
+
+func (c *Clientset) FlannelNetworks(namespace string) v1alpha1.FlannelNetworkInterface {
+	return &flannelNetworks{c: c, ns: namespace}
+}
+
+func (c *Clientset) ClusterFlannelNetworks() v1alpha1.ClusterFlannelNetworkInterface {
+	return &clusterFlannelNetworks{f: &flannelNetworks{c: c.cluster}}
+}
+
+// Stop ends all watches.
+func (c *Clientset) Stop() {
+	c.broadcaster.Shutdown()
+	c.cluster.broadcaster.Shutdown()
+}
+
+// store saves a copy of flan with a new resource version and notifies the
+// watchers. c.mtx must be held.
+func (c *Clientset) store(flan *v1alpha1.FlannelNetwork, event watch.EventType) *v1alpha1.FlannelNetwork {
+	c.resourceVersion++
+	flan.ResourceVersion = strconv.Itoa(c.resourceVersion)
+	c.objects[key(flan.Namespace, flan.Name)] = flan.DeepCopy()
+	c.broadcaster.Action(event, flan.DeepCopy())
+	return flan
+}
+
+type flannelNetworks struct {
+	c  *Clientset
+	ns string
+}
+
+func key(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// namespaceOf returns the namespace obj is written to, which has to agree
+// with the namespace of the client.
+func (f *flannelNetworks) namespaceOf(obj *v1alpha1.FlannelNetwork) (string, error) {
+	switch {
+	case obj.Namespace == "":
+		return f.ns, nil
+	case f.ns == "" || obj.Namespace == f.ns:
+		return obj.Namespace, nil
+	}
+	return "", errors.NewBadRequest(fmt.Sprintf("namespace %s of the object doesn't match the client's namespace %s", obj.Namespace, f.ns))
+}
+
+func (f *flannelNetworks) Create(obj *v1alpha1.FlannelNetwork) (*v1alpha1.FlannelNetwork, error) {
+	ns, err := f.namespaceOf(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	f.c.mtx.Lock()
+	defer f.c.mtx.Unlock()
+
+	if _, ok := f.c.objects[key(ns, obj.Name)]; ok {
+		return nil, errors.NewAlreadyExists(groupResource, obj.Name)
+	}
+
+	flan := obj.DeepCopy()
+	flan.Namespace = ns
+	flan.CreationTimestamp = unversioned.Now()
+	return f.c.store(flan, watch.Added), nil
+}
+
+func (f *flannelNetworks) Get(name string) (*v1alpha1.FlannelNetwork, error) {
+	f.c.mtx.Lock()
+	defer f.c.mtx.Unlock()
+
+	flan, ok := f.c.objects[key(f.ns, name)]
+	if !ok {
+		return nil, errors.NewNotFound(groupResource, name)
+	}
+	return flan.DeepCopy(), nil
+}
+
+func (f *flannelNetworks) Update(obj *v1alpha1.FlannelNetwork) (*v1alpha1.FlannelNetwork, error) {
+	ns, err := f.namespaceOf(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	f.c.mtx.Lock()
+	defer f.c.mtx.Unlock()
+
+	existing, ok := f.c.objects[key(ns, obj.Name)]
+	if !ok {
+		return nil, errors.NewNotFound(groupResource, obj.Name)
+	}
+	if obj.ResourceVersion != "" && obj.ResourceVersion != existing.ResourceVersion {
+		return nil, errors.NewConflict(groupResource, obj.Name, fmt.Errorf("resource version %s is outdated", obj.ResourceVersion))
+	}
+
+	flan := obj.DeepCopy()
+	flan.Namespace = ns
+	flan.CreationTimestamp = existing.CreationTimestamp
+	return f.c.store(flan, watch.Modified), nil
+}
+
+func (f *flannelNetworks) UpdateStatus(obj *v1alpha1.FlannelNetwork) (*v1alpha1.FlannelNetwork, error) {
+	return f.Update(obj)
+}
+
+func (f *flannelNetworks) Delete(name string, options *v1.DeleteOptions) error {
+	f.c.mtx.Lock()
+	defer f.c.mtx.Unlock()
+
+	return f.delete(key(f.ns, name), name)
+}
+
+// delete removes the object and notifies the watchers. c.mtx must be held.
+func (f *flannelNetworks) delete(k, name string) error {
+	flan, ok := f.c.objects[k]
+	if !ok {
+		return errors.NewNotFound(groupResource, name)
+	}
+	delete(f.c.objects, k)
+	f.c.broadcaster.Action(watch.Deleted, flan.DeepCopy())
+	return nil
+}
+
+func (f *flannelNetworks) DeleteCollection(options *v1.DeleteOptions, listOptions api.ListOptions) error {
+	f.c.mtx.Lock()
+	defer f.c.mtx.Unlock()
+
+	for k, flan := range f.c.objects {
+		if f.matches(flan, listOptions) {
+			f.delete(k, flan.Name)
+		}
+	}
+	return nil
+}
+
+func (f *flannelNetworks) List(opts api.ListOptions) (*v1alpha1.FlannelNetworkList, error) {
+	f.c.mtx.Lock()
+	defer f.c.mtx.Unlock()
+
+	list := &v1alpha1.FlannelNetworkList{}
+	list.ResourceVersion = strconv.Itoa(f.c.resourceVersion)
+	for _, flan := range f.c.objects {
+		if f.matches(flan, opts) {
+			list.Items = append(list.Items, flan.DeepCopy())
+		}
+	}
+	return list, nil
+}
+
+// Watch reports the changes from now on. Unlike the API server, it can't
+// replay events since opts.ResourceVersion.
+func (f *flannelNetworks) Watch(opts api.ListOptions) (watch.Interface, error) {
+	return watch.Filter(f.c.broadcaster.Watch(), func(in watch.Event) (watch.Event, bool) {
+		return in, f.matches(in.Object.(*v1alpha1.FlannelNetwork), opts)
+	}), nil
+}
+
+// Patch applies JSON merge patches. Custom resources don't support
+// strategic merge patches, and JSON patches aren't needed by the operator.
+func (f *flannelNetworks) Patch(name string, pt api.PatchType, data []byte, subresources ...string) (*v1alpha1.FlannelNetwork, error) {
+	if pt != api.MergePatchType {
+		return nil, errors.NewBadRequest(fmt.Sprintf("patch type %s is not supported", pt))
+	}
+
+	f.c.mtx.Lock()
+	defer f.c.mtx.Unlock()
+
+	existing, ok := f.c.objects[key(f.ns, name)]
+	if !ok {
+		return nil, errors.NewNotFound(groupResource, name)
+	}
+
+	var doc, patch interface{}
+	b, err := json.Marshal(existing)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &patch); err != nil {
+		return nil, errors.NewBadRequest(fmt.Sprintf("invalid patch: %s", err))
+	}
+	if b, err = json.Marshal(mergePatch(doc, patch)); err != nil {
+		return nil, err
+	}
+
+	var flan v1alpha1.FlannelNetwork
+	if err := json.Unmarshal(b, &flan); err != nil {
+		return nil, errors.NewBadRequest(fmt.Sprintf("invalid patch: %s", err))
+	}
+	flan.Namespace = existing.Namespace
+	flan.Name = existing.Name
+	flan.CreationTimestamp = existing.CreationTimestamp
+	return f.c.store(&flan, watch.Modified), nil
+}
+
+func (f *flannelNetworks) matches(flan *v1alpha1.FlannelNetwork, opts api.ListOptions) bool {
+	if f.ns != "" && flan.Namespace != f.ns {
+		return false
+	}
+	if opts.LabelSelector != nil && !opts.LabelSelector.Matches(labels.Set(flan.Labels)) {
+		return false
+	}
+	if opts.FieldSelector != nil && !opts.FieldSelector.Matches(fields.Set{
+		"metadata.name":      flan.Name,
+		"metadata.namespace": flan.Namespace,
+	}) {
+		return false
+	}
+	return true
+}
+
+// mergePatch applies a JSON merge patch (RFC 7386) to doc.
+func mergePatch(doc, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	d, ok := doc.(map[string]interface{})
+	if !ok {
+		d = map[string]interface{}{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(d, k)
+		} else {
+			d[k] = mergePatch(d[k], v)
+		}
+	}
+	return d
+}
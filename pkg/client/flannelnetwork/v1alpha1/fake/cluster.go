@@ -0,0 +1,80 @@
+package fake
+
+import (
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1alpha1"
+
+	"k8s.io/client-go/1.5/pkg/api"
+	"k8s.io/client-go/1.5/pkg/api/errors"
+	"k8s.io/client-go/1.5/pkg/api/v1"
+	"k8s.io/client-go/1.5/pkg/watch"
+)
+
+// clusterFlannelNetworks converts between ClusterFlannelNetworks and the
+// FlannelNetworks without a namespace kept by f.
+type clusterFlannelNetworks struct {
+	f *flannelNetworks
+}
+
+func (c *clusterFlannelNetworks) convert(flan *v1alpha1.FlannelNetwork, err error) (*v1alpha1.ClusterFlannelNetwork, error) {
+	if err != nil {
+		return nil, err
+	}
+	return v1alpha1.ClusterFlannelNetworkFrom(flan), nil
+}
+
+func (c *clusterFlannelNetworks) Create(obj *v1alpha1.ClusterFlannelNetwork) (*v1alpha1.ClusterFlannelNetwork, error) {
+	if obj.Namespace != "" {
+		return nil, errors.NewBadRequest("ClusterFlannelNetworks have no namespace")
+	}
+	return c.convert(c.f.Create(obj.AsFlannelNetwork()))
+}
+
+func (c *clusterFlannelNetworks) Get(name string) (*v1alpha1.ClusterFlannelNetwork, error) {
+	return c.convert(c.f.Get(name))
+}
+
+func (c *clusterFlannelNetworks) Update(obj *v1alpha1.ClusterFlannelNetwork) (*v1alpha1.ClusterFlannelNetwork, error) {
+	if obj.Namespace != "" {
+		return nil, errors.NewBadRequest("ClusterFlannelNetworks have no namespace")
+	}
+	return c.convert(c.f.Update(obj.AsFlannelNetwork()))
+}
+
+func (c *clusterFlannelNetworks) UpdateStatus(obj *v1alpha1.ClusterFlannelNetwork) (*v1alpha1.ClusterFlannelNetwork, error) {
+	return c.Update(obj)
+}
+
+func (c *clusterFlannelNetworks) Delete(name string, options *v1.DeleteOptions) error {
+	return c.f.Delete(name, options)
+}
+
+func (c *clusterFlannelNetworks) DeleteCollection(options *v1.DeleteOptions, listOptions api.ListOptions) error {
+	return c.f.DeleteCollection(options, listOptions)
+}
+
+func (c *clusterFlannelNetworks) List(opts api.ListOptions) (*v1alpha1.ClusterFlannelNetworkList, error) {
+	list, err := c.f.List(opts)
+	if err != nil {
+		return nil, err
+	}
+	out := &v1alpha1.ClusterFlannelNetworkList{ListMeta: list.ListMeta}
+	for _, flan := range list.Items {
+		out.Items = append(out.Items, v1alpha1.ClusterFlannelNetworkFrom(flan))
+	}
+	return out, nil
+}
+
+func (c *clusterFlannelNetworks) Watch(opts api.ListOptions) (watch.Interface, error) {
+	w, err := c.f.Watch(opts)
+	if err != nil {
+		return nil, err
+	}
+	return watch.Filter(w, func(in watch.Event) (watch.Event, bool) {
+		in.Object = v1alpha1.ClusterFlannelNetworkFrom(in.Object.(*v1alpha1.FlannelNetwork))
+		return in, true
+	}), nil
+}
+
+func (c *clusterFlannelNetworks) Patch(name string, pt api.PatchType, data []byte, subresources ...string) (*v1alpha1.ClusterFlannelNetwork, error) {
+	return c.convert(c.f.Patch(name, pt, data, subresources...))
+}
@@ -2,22 +2,16 @@ package v1alpha1
 
 import (
 	"encoding/json"
+	"strconv"
 
 	"github.com/op/go-logging"
 
-	// metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/1.5/pkg/api"
+	"k8s.io/client-go/1.5/pkg/api/unversioned"
 	"k8s.io/client-go/1.5/pkg/api/v1"
-
-	// why not? rest.Interface is missing
-	// "k8s.io/client-go/1.5/rest"
-	"k8s.io/client-go/1.5/rest"
-
-	"k8s.io/client-go/1.5/dynamic"
 	"k8s.io/client-go/1.5/pkg/runtime"
 	"k8s.io/client-go/1.5/pkg/watch"
-	// "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/client-go/1.5/pkg/api/unversioned"
+	"k8s.io/client-go/1.5/rest"
 )
 
 var (
@@ -30,119 +24,138 @@ const (
 )
 
 type FlannelNetworksGetter interface {
-	Flannels(namespace string) FlannelNetworkInterface
+	FlannelNetworks(namespace string) FlannelNetworkInterface
 }
 
+// FlannelNetworkInterface has methods to work with FlannelNetwork resources.
 type FlannelNetworkInterface interface {
 	Create(*FlannelNetwork) (*FlannelNetwork, error)
 	Get(name string) (*FlannelNetwork, error)
 	Update(*FlannelNetwork) (*FlannelNetwork, error)
-	Delete(name string, options *v1.DeleteOptions)
-	List(opts api.ListOptions) (runtime.Object, error)
+	UpdateStatus(*FlannelNetwork) (*FlannelNetwork, error)
+	Delete(name string, options *v1.DeleteOptions) error
+	DeleteCollection(options *v1.DeleteOptions, listOptions api.ListOptions) error
+	List(opts api.ListOptions) (*FlannelNetworkList, error)
 	Watch(opts api.ListOptions) (watch.Interface, error)
+	Patch(name string, pt api.PatchType, data []byte, subresources ...string) (*FlannelNetwork, error)
 }
 
+// flannelnetworks implements FlannelNetworkInterface. Custom resources
+// aren't registered with the client's scheme, so objects and options are
+// encoded as JSON here instead of through the REST client's codecs.
 type flannelnetworks struct {
 	restClient *rest.RESTClient
-	client     *dynamic.ResourceClient
 	ns         string
 }
 
-func newFlannelNetworks(r *rest.RESTClient, c *dynamic.Client, namespace string) *flannelnetworks {
-	log.Notice("Called newFlannelNetworks")
-
+func newFlannelNetworks(r *rest.RESTClient, namespace string) *flannelnetworks {
 	return &flannelnetworks{
-		r,
-		c.Resource(
-			&unversioned.APIResource{
-				Kind:       TPRFlannelKind,
-				Name:       TPRFlannelName,
-				Namespaced: true,
-			},
-			namespace,
-		),
-		namespace,
+		restClient: r,
+		ns:         namespace,
 	}
 }
 
 func (f *flannelnetworks) Create(o *FlannelNetwork) (*FlannelNetwork, error) {
-
 	log.Notice("Creating FlannelNetwork", o.Name)
 
-	up, err := UnstructuredFromFlannelNetwork(o)
+	body, err := encodeFlannelNetwork(o)
 	if err != nil {
 		return nil, err
 	}
-
-	up, err = f.client.Create(up)
-	if err != nil {
-		return nil, err
-	}
-
-	return FlannelNetworkFromUnstructured(up)
+	return decodeFlannelNetwork(f.restClient.Post().
+		Namespace(f.ns).
+		Resource(TPRFlannelName).
+		Body(body).
+		DoRaw())
 }
 
 func (f *flannelnetworks) Get(name string) (*FlannelNetwork, error) {
-
-	obj, err := f.client.Get(name)
-	if err != nil {
-		return nil, err
-	}
-	return FlannelNetworkFromUnstructured(obj)
+	return decodeFlannelNetwork(f.restClient.Get().
+		Namespace(f.ns).
+		Resource(TPRFlannelName).
+		Name(name).
+		DoRaw())
 }
 
 func (f *flannelnetworks) Update(o *FlannelNetwork) (*FlannelNetwork, error) {
-
-	up, err := UnstructuredFromFlannelNetwork(o)
+	body, err := encodeFlannelNetwork(o)
 	if err != nil {
 		return nil, err
 	}
-
-	up, err = f.client.Update(up)
-	if err != nil {
-		return nil, err
-	}
-
-	return FlannelNetworkFromUnstructured(up)
+	return decodeFlannelNetwork(f.restClient.Put().
+		Namespace(f.ns).
+		Resource(TPRFlannelName).
+		Name(o.Name).
+		Body(body).
+		DoRaw())
 }
 
-// TODO had to remove the return type "error" because of
-// pkg/client/flannel/v1alpha1/client.go:25: cannot use newFlannelNetworks(c.restClient, c.dynamicClient, namespace) (type *flannelnetworks) as type FlannelNetworkInterface in return argument:
-func (f *flannelnetworks) Delete(name string, options *v1.DeleteOptions) {
+// UpdateStatus writes the status of the FlannelNetwork. The
+// CustomResourceDefinitions have no status subresource, so this replaces the
+// whole object like Update.
+func (f *flannelnetworks) UpdateStatus(o *FlannelNetwork) (*FlannelNetwork, error) {
+	return f.Update(o)
+}
 
+func (f *flannelnetworks) Delete(name string, options *v1.DeleteOptions) error {
 	log.Notice("Deleting FlannelNetwork", name)
 
-	if err := f.client.Delete(name, options); err != nil {
-		log.Error("Could not delete %v - %v", name, err)
+	req := f.restClient.Delete().
+		Namespace(f.ns).
+		Resource(TPRFlannelName).
+		Name(name)
+	if options != nil {
+		body, err := encodeDeleteOptions(options)
+		if err != nil {
+			return err
+		}
+		req = req.Body(body)
 	}
+	_, err := req.DoRaw()
+	return err
 }
 
-func (f *flannelnetworks) List(opts api.ListOptions) (runtime.Object, error) {
+func (f *flannelnetworks) DeleteCollection(options *v1.DeleteOptions, listOptions api.ListOptions) error {
+	log.Notice("Deleting FlannelNetworks in namespace", f.ns)
 
-	req := f.restClient.Get().
+	req := withListOptions(f.restClient.Delete().
 		Namespace(f.ns).
-		Resource("flannelnetworks").
-		FieldsSelectorParam(nil)
+		Resource(TPRFlannelName), listOptions)
+	if options != nil {
+		body, err := encodeDeleteOptions(options)
+		if err != nil {
+			return err
+		}
+		req = req.Body(body)
+	}
+	_, err := req.DoRaw()
+	return err
+}
 
-	b, err := req.DoRaw()
+func (f *flannelnetworks) List(opts api.ListOptions) (*FlannelNetworkList, error) {
+	b, err := withListOptions(f.restClient.Get().
+		Namespace(f.ns).
+		Resource(TPRFlannelName), opts).
+		DoRaw()
 	if err != nil {
-		log.Notice("flannelnetworks.List did not work out:", err)
 		return nil, err
 	}
-	var flan FlannelNetworkList
-	log.Notice("*flannelnetworks.List finished")
-	return &flan, json.Unmarshal(b, &flan)
+
+	var list FlannelNetworkList
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, err
+	}
+	for _, flan := range list.Items {
+		setTypeMeta(flan)
+	}
+	return &list, nil
 }
 
 func (f *flannelnetworks) Watch(opts api.ListOptions) (watch.Interface, error) {
-	log.Notice("flannelnetworks.Watch")
-
-	r, err := f.restClient.Get().
+	r, err := withListOptions(f.restClient.Get().
 		Prefix("watch").
 		Namespace(f.ns).
-		Resource("flannelnetworks").
-		// VersionedParams(&options, v1.ParameterCodec).
-		FieldsSelectorParam(nil).
+		Resource(TPRFlannelName), opts).
 		Stream()
 	if err != nil {
 		return nil, err
@@ -153,6 +166,64 @@ func (f *flannelnetworks) Watch(opts api.ListOptions) (watch.Interface, error) {
 	}), nil
 }
 
+func (f *flannelnetworks) Patch(name string, pt api.PatchType, data []byte, subresources ...string) (*FlannelNetwork, error) {
+	return decodeFlannelNetwork(f.restClient.Patch(pt).
+		Namespace(f.ns).
+		Resource(TPRFlannelName).
+		SubResource(subresources...).
+		Name(name).
+		Body(data).
+		DoRaw())
+}
+
+// withListOptions adds the list options to the query of the request. The
+// parameter codec can't convert them to our unregistered group version.
+func withListOptions(req *rest.Request, opts api.ListOptions) *rest.Request {
+	if opts.LabelSelector != nil && !opts.LabelSelector.Empty() {
+		req = req.Param("labelSelector", opts.LabelSelector.String())
+	}
+	if opts.FieldSelector != nil && !opts.FieldSelector.Empty() {
+		req = req.Param("fieldSelector", opts.FieldSelector.String())
+	}
+	if opts.ResourceVersion != "" {
+		req = req.Param("resourceVersion", opts.ResourceVersion)
+	}
+	if opts.TimeoutSeconds != nil {
+		req = req.Param("timeoutSeconds", strconv.FormatInt(*opts.TimeoutSeconds, 10))
+	}
+	return req
+}
+
+func setTypeMeta(f *FlannelNetwork) {
+	f.TypeMeta.Kind = TPRFlannelKind
+	f.TypeMeta.APIVersion = TPRGroup + "/" + TPRVersion
+}
+
+// encodeFlannelNetwork works on a copy, f may come from an informer cache.
+func encodeFlannelNetwork(f *FlannelNetwork) ([]byte, error) {
+	f = f.DeepCopy()
+	setTypeMeta(f)
+	return json.Marshal(f)
+}
+
+func decodeFlannelNetwork(b []byte, err error) (*FlannelNetwork, error) {
+	if err != nil {
+		return nil, err
+	}
+	var f FlannelNetwork
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+	setTypeMeta(&f)
+	return &f, nil
+}
+
+func encodeDeleteOptions(options *v1.DeleteOptions) ([]byte, error) {
+	opts := *options
+	opts.TypeMeta = unversioned.TypeMeta{Kind: "DeleteOptions", APIVersion: "v1"}
+	return json.Marshal(&opts)
+}
+
 // FlannelNetworkFromUnstructured unmarshals a FlannelNetwork object from dynamic client's unstructured
 func FlannelNetworkFromUnstructured(r *runtime.Unstructured) (*FlannelNetwork, error) {
 	b, err := json.Marshal(r.Object)
@@ -163,16 +234,13 @@ func FlannelNetworkFromUnstructured(r *runtime.Unstructured) (*FlannelNetwork, e
 	if err := json.Unmarshal(b, &f); err != nil {
 		return nil, err
 	}
-	f.TypeMeta.Kind = TPRFlannelKind
-	f.TypeMeta.APIVersion = TPRGroup + "/" + TPRVersion
+	setTypeMeta(&f)
 	return &f, nil
 }
 
 // UnstructuredFromFlannelNetwork marshals a FlannelNetwork object into dynamic client's unstructured
 func UnstructuredFromFlannelNetwork(f *FlannelNetwork) (*runtime.Unstructured, error) {
-	f.TypeMeta.Kind = TPRFlannelKind
-	f.TypeMeta.APIVersion = TPRGroup + "/" + TPRVersion
-	b, err := json.Marshal(f)
+	b, err := encodeFlannelNetwork(f)
 	if err != nil {
 		return nil, err
 	}
@@ -200,5 +268,6 @@ func (d *flannelNetworkDecoder) Decode() (action watch.EventType, object runtime
 	if err := d.dec.Decode(&e); err != nil {
 		return watch.Error, nil, err
 	}
+	setTypeMeta(&e.Object)
 	return e.Type, &e.Object, nil
 }
@@ -0,0 +1,117 @@
+package v1alpha1
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"k8s.io/client-go/1.5/pkg/api"
+	"k8s.io/client-go/1.5/pkg/runtime"
+	"k8s.io/client-go/1.5/pkg/watch"
+	"k8s.io/client-go/1.5/tools/cache"
+)
+
+const (
+	// VNIIndex indexes FlannelNetworks by spec.vni in canonical form, see
+	// CanonicalVNI.
+	VNIIndex = "vni"
+	// CIDRIndex indexes FlannelNetworks by spec.cidr in canonical form,
+	// see CanonicalCIDR.
+	CIDRIndex = "cidr"
+)
+
+// FlannelNetworkInformer provides access to a shared informer and lister
+// for FlannelNetworks.
+type FlannelNetworkInformer interface {
+	Informer() cache.SharedIndexInformer
+	Lister() FlannelNetworkLister
+}
+
+type flannelNetworkInformer struct {
+	informer cache.SharedIndexInformer
+}
+
+// NewFlannelNetworkInformer returns an informer for the FlannelNetworks in
+// namespace, api.NamespaceAll for all of them. Its indexer has the
+// namespace, VNI and CIDR indexes the lister relies on.
+func NewFlannelNetworkInformer(client FlannelNetworksGetter, namespace string, resyncPeriod time.Duration) FlannelNetworkInformer {
+	informer := cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options api.ListOptions) (runtime.Object, error) {
+				return client.FlannelNetworks(namespace).List(options)
+			},
+			WatchFunc: func(options api.ListOptions) (watch.Interface, error) {
+				return client.FlannelNetworks(namespace).Watch(options)
+			},
+		},
+		&FlannelNetwork{}, resyncPeriod,
+		cache.Indexers{
+			cache.NamespaceIndex: cache.MetaNamespaceIndexFunc,
+			VNIIndex:             VNIIndexFunc,
+			CIDRIndex:            CIDRIndexFunc,
+		},
+	)
+	return &flannelNetworkInformer{informer: informer}
+}
+
+func (f *flannelNetworkInformer) Informer() cache.SharedIndexInformer {
+	return f.informer
+}
+
+func (f *flannelNetworkInformer) Lister() FlannelNetworkLister {
+	return NewFlannelNetworkLister(f.informer.GetIndexer())
+}
+
+// NewClusterFlannelNetworkInformer returns an informer for the
+// ClusterFlannelNetworks. There are few of them, so it has no indexes.
+func NewClusterFlannelNetworkInformer(client ClusterFlannelNetworksGetter, resyncPeriod time.Duration) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options api.ListOptions) (runtime.Object, error) {
+				return client.ClusterFlannelNetworks().List(options)
+			},
+			WatchFunc: func(options api.ListOptions) (watch.Interface, error) {
+				return client.ClusterFlannelNetworks().Watch(options)
+			},
+		},
+		&ClusterFlannelNetwork{}, resyncPeriod, cache.Indexers{},
+	)
+}
+
+// VNIIndexFunc is the index function of VNIIndex.
+func VNIIndexFunc(obj interface{}) ([]string, error) {
+	flan, ok := obj.(*FlannelNetwork)
+	if !ok || flan.Spec.VNI == "" {
+		return nil, nil
+	}
+	return []string{CanonicalVNI(flan.Spec.VNI)}, nil
+}
+
+// CIDRIndexFunc is the index function of CIDRIndex.
+func CIDRIndexFunc(obj interface{}) ([]string, error) {
+	flan, ok := obj.(*FlannelNetwork)
+	if !ok || flan.Spec.Cidr == "" {
+		return nil, nil
+	}
+	return []string{CanonicalCIDR(flan.Spec.Cidr)}, nil
+}
+
+// CanonicalCIDR masks the host bits of a CIDR, so 10.1.2.3/16 and
+// 10.1.0.0/16 are the same network. Invalid CIDRs are returned unchanged.
+func CanonicalCIDR(cidr string) string {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return cidr
+	}
+	return ipnet.String()
+}
+
+// CanonicalVNI formats a VNI as a plain decimal number, so 01 and 1 are the
+// same VNI. Invalid VNIs are returned unchanged.
+func CanonicalVNI(vni string) string {
+	n, err := strconv.Atoi(vni)
+	if err != nil {
+		return vni
+	}
+	return strconv.Itoa(n)
+}
@@ -0,0 +1,100 @@
+package v1alpha1
+
+import (
+	"k8s.io/client-go/1.5/pkg/api/errors"
+	"k8s.io/client-go/1.5/pkg/api/unversioned"
+	"k8s.io/client-go/1.5/pkg/labels"
+	"k8s.io/client-go/1.5/tools/cache"
+)
+
+// FlannelNetworkLister lists FlannelNetworks from a shared informer's cache.
+// The returned objects are shared with the cache and must not be modified.
+type FlannelNetworkLister interface {
+	// List lists the FlannelNetworks of all namespaces.
+	List(selector labels.Selector) ([]*FlannelNetwork, error)
+	// ByVNI returns the FlannelNetworks with the given VNI.
+	ByVNI(vni string) ([]*FlannelNetwork, error)
+	// ByCIDR returns the FlannelNetworks with the given CIDR.
+	ByCIDR(cidr string) ([]*FlannelNetwork, error)
+	// FlannelNetworks returns a lister for the FlannelNetworks of a
+	// namespace.
+	FlannelNetworks(namespace string) FlannelNetworkNamespaceLister
+}
+
+// FlannelNetworkNamespaceLister lists the FlannelNetworks of one namespace.
+type FlannelNetworkNamespaceLister interface {
+	List(selector labels.Selector) ([]*FlannelNetwork, error)
+	Get(name string) (*FlannelNetwork, error)
+}
+
+type flannelNetworkLister struct {
+	indexer cache.Indexer
+}
+
+// NewFlannelNetworkLister returns a lister on top of an indexer with the
+// indexes of NewFlannelNetworkInformer.
+func NewFlannelNetworkLister(indexer cache.Indexer) FlannelNetworkLister {
+	return &flannelNetworkLister{indexer: indexer}
+}
+
+func (l *flannelNetworkLister) List(selector labels.Selector) ([]*FlannelNetwork, error) {
+	return filterFlannelNetworks(l.indexer.List(), selector), nil
+}
+
+func (l *flannelNetworkLister) ByVNI(vni string) ([]*FlannelNetwork, error) {
+	objs, err := l.indexer.ByIndex(VNIIndex, CanonicalVNI(vni))
+	if err != nil {
+		return nil, err
+	}
+	return filterFlannelNetworks(objs, labels.Everything()), nil
+}
+
+func (l *flannelNetworkLister) ByCIDR(cidr string) ([]*FlannelNetwork, error) {
+	objs, err := l.indexer.ByIndex(CIDRIndex, CanonicalCIDR(cidr))
+	if err != nil {
+		return nil, err
+	}
+	return filterFlannelNetworks(objs, labels.Everything()), nil
+}
+
+func (l *flannelNetworkLister) FlannelNetworks(namespace string) FlannelNetworkNamespaceLister {
+	return &flannelNetworkNamespaceLister{indexer: l.indexer, namespace: namespace}
+}
+
+type flannelNetworkNamespaceLister struct {
+	indexer   cache.Indexer
+	namespace string
+}
+
+func (l *flannelNetworkNamespaceLister) List(selector labels.Selector) ([]*FlannelNetwork, error) {
+	objs, err := l.indexer.ByIndex(cache.NamespaceIndex, l.namespace)
+	if err != nil {
+		return nil, err
+	}
+	return filterFlannelNetworks(objs, selector), nil
+}
+
+func (l *flannelNetworkNamespaceLister) Get(name string) (*FlannelNetwork, error) {
+	obj, exists, err := l.indexer.GetByKey(l.namespace + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.NewNotFound(unversioned.GroupResource{Group: TPRGroup, Resource: TPRFlannelName}, name)
+	}
+	return obj.(*FlannelNetwork), nil
+}
+
+func filterFlannelNetworks(objs []interface{}, selector labels.Selector) []*FlannelNetwork {
+	var flans []*FlannelNetwork
+	for _, obj := range objs {
+		flan, ok := obj.(*FlannelNetwork)
+		if !ok {
+			continue
+		}
+		if selector.Matches(labels.Set(flan.Labels)) {
+			flans = append(flans, flan)
+		}
+	}
+	return flans
+}
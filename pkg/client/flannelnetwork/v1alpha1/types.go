@@ -6,7 +6,8 @@ import (
 	"k8s.io/client-go/1.5/pkg/api/v1"
 )
 
-// Prometheus defines a Prometheus deployment.
+// FlannelNetwork is an overlay network with its own flannel client on every
+// node.
 type FlannelNetwork struct {
 	unversioned.TypeMeta `json:",inline"`
 	v1.ObjectMeta        `json:"metadata,omitempty"`
@@ -21,24 +22,114 @@ type FlannelNetworkList struct {
 	Items []*FlannelNetwork `json:"items"`
 }
 
+// ClusterFlannelNetwork is a FlannelNetwork without a namespace, for
+// networks provided to the whole platform rather than to a single team. It
+// shares VNIs, CIDRs and defaults with the FlannelNetworks.
+type ClusterFlannelNetwork struct {
+	unversioned.TypeMeta `json:",inline"`
+	v1.ObjectMeta        `json:"metadata,omitempty"`
+	Spec                 FlannelNetworkSpec    `json:"spec"`
+	Status               *FlannelNetworkStatus `json:"status,omitempty"`
+}
+
+type ClusterFlannelNetworkList struct {
+	unversioned.TypeMeta `json:",inline"`
+	unversioned.ListMeta `json:"metadata,omitempty"`
+
+	Items []*ClusterFlannelNetwork `json:"items"`
+}
+
 type FlannelNetworkSpec struct {
 	VNI  string `json:"vni,omitempty"`
 	Cidr string `json:"cidr,omitempty"`
+	// Backend is the flannel backend type of the network. Only vxlan, the
+	// default, is supported.
+	Backend string `json:"backend,omitempty"`
+	// FlannelNetworks this network may open connections to, either by name
+	// within the same namespace or as <namespace>/<name>. ClusterFlannelNetworks
+	// are referred to as /<name>. Traffic to all other networks is dropped.
+	Peers []string `json:"peers,omitempty"`
+	// Whether traffic leaving the network is masqueraded. Defaults to the
+	// operator's -ip-masq flag.
+	IPMasq *bool `json:"ipMasq,omitempty"`
+	// MTU of the pod interfaces. Defaults to the operator's -mtu flag.
+	MTU int `json:"mtu,omitempty"`
+	// Destination CIDRs that traffic leaving the network is not
+	// masqueraded for.
+	MasqueradeExceptions []string `json:"masqueradeExceptions,omitempty"`
+	// Flannel release the client of the network runs, e.g. v0.7.0.
+	// Defaults to the operator's -flannel-version flag.
+	FlannelVersion string `json:"flannelVersion,omitempty"`
+	// Paused freezes the objects managed for the network, e.g. during
+	// maintenance. Deleting the network still removes them.
+	Paused bool `json:"paused,omitempty"`
+	// Resources of the flannel client container. Defaults to the
+	// operator's -client-requests, -client-limits and -client-qos flags.
+	Resources *ResourceRequirements `json:"resources,omitempty"`
+	// PodTemplateOverrides are merged onto the pod template of the flannel
+	// client after the operator's -pod-template-overrides.
+	PodTemplateOverrides *PodTemplateOverrides `json:"podTemplateOverrides,omitempty"`
+}
+
+// PodTemplateOverrides are strategically merged onto the pod templates the
+// operator generates, e.g. to meet the policies of a cluster.
+type PodTemplateOverrides struct {
+	// Labels and Annotations are added to the pods. The labels the
+	// operator selects its pods by can't be overridden.
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// ImagePullSecrets name Secrets in kube-system to pull the images
+	// with.
+	ImagePullSecrets []string `json:"imagePullSecrets,omitempty"`
+	// ImageRegistry replaces the registry of all images, e.g. with a
+	// mirror like registry.example.com:5000.
+	ImageRegistry string `json:"imageRegistry,omitempty"`
+	// Sidecars are added to the pods. They can't be named like one of the
+	// operator's containers.
+	Sidecars []v1.Container `json:"sidecars,omitempty"`
+}
+
+// ResourceRequirements are the resources of a flannel container.
+type ResourceRequirements struct {
+	// Requests and Limits map cpu and memory to quantities, e.g.
+	// {"cpu": "100m", "memory": "50Mi"}.
+	Requests map[string]string `json:"requests,omitempty"`
+	Limits   map[string]string `json:"limits,omitempty"`
+	// QOSClass is the QoS class the pod is meant to get: Guaranteed sets
+	// the requests to the limits, BestEffort allows no resources at all
+	// and Burstable needs at least one request or limit. Empty takes the
+	// resources as they are.
+	QOSClass string `json:"qosClass,omitempty"`
 }
 
 type FlannelNetworkStatus struct {
 	// Represents whether any actions on the underlaying managed objects are
-	// being performed. Only delete actions will be performed.
+	// being performed. Only delete actions will be performed. Mirrors
+	// spec.paused once the operator has seen it.
 	Paused bool `json:"paused"`
-	// Total number of non-terminated pods targeted by this Prometheus deployment
-	// (their labels match the selector).
+	// Replicas is the number of non-terminated flannel client pods.
 	Replicas int32 `json:"replicas"`
-	// Total number of non-terminated pods targeted by this Prometheus deployment
-	// that have the desired version spec.
+	// UpdatedReplicas is the number of flannel client pods of the current
+	// spec.
 	UpdatedReplicas int32 `json:"updatedReplicas"`
-	// Total number of available pods (ready for at least minReadySeconds)
-	// targeted by this Prometheus deployment.
+	// AvailableReplicas is the number of flannel client pods ready for
+	// at least minReadySeconds.
 	AvailableReplicas int32 `json:"availableReplicas"`
-	// Total number of unavailable pods targeted by this Prometheus deployment.
+	// UnavailableReplicas is the number of flannel client pods that are
+	// not available.
 	UnavailableReplicas int32 `json:"unavailableReplicas"`
+	// Ready is true once all flannel client pods are up to date and have
+	// written the subnet lease of the network, see their readiness probe.
+	Ready bool `json:"ready"`
+	// DiagnosedNodes is the number of nodes the diagnostics agent
+	// reported from, AttachedNodes the number of those with the VXLAN
+	// device of the network.
+	DiagnosedNodes int32 `json:"diagnosedNodes,omitempty"`
+	AttachedNodes  int32 `json:"attachedNodes,omitempty"`
+	// DiagnosticsMessage lists the problems the diagnostics agents found
+	// with the network's devices, e.g. a device that is down.
+	DiagnosticsMessage string `json:"diagnosticsMessage,omitempty"`
+	// Message tells why the operator ignores the network, e.g. because
+	// an older network has the same VNI.
+	Message string `json:"message,omitempty"`
 }
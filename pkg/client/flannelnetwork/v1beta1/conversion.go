@@ -0,0 +1,253 @@
+package v1beta1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1alpha1"
+)
+
+// PlacementAnnotation keeps spec.placement on v1alpha1 objects, which have
+// no field for it, so it survives the conversion to v1alpha1 and back.
+const PlacementAnnotation = TPRGroup + "/placement"
+
+// RawSpecAnnotation keeps the v1alpha1 strings that don't convert to the
+// typed fields unchanged on v1beta1 objects, e.g. a VNI of "01" or a CIDR
+// that doesn't parse. Stored objects from before validation was added must
+// not break the conversion, so fields that don't parse are left zero.
+const RawSpecAnnotation = TPRGroup + "/v1alpha1-spec"
+
+// rawSpec is the value of RawSpecAnnotation.
+type rawSpec struct {
+	VNI                  string   `json:"vni,omitempty"`
+	Cidr                 string   `json:"cidr,omitempty"`
+	MasqueradeExceptions []string `json:"masqueradeExceptions,omitempty"`
+}
+
+// ParseCIDR parses a CIDR like 10.1.0.0/16. The address is kept as given.
+func ParseCIDR(s string) (CIDR, error) {
+	ip, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		return CIDR{}, err
+	}
+	ones, _ := ipnet.Mask.Size()
+	return CIDR{Address: ip.String(), PrefixLength: ones}, nil
+}
+
+func (c CIDR) String() string {
+	if c.Address == "" {
+		return ""
+	}
+	return c.Address + "/" + strconv.Itoa(c.PrefixLength)
+}
+
+// PlacementFromAnnotations returns the placement stored in the annotations
+// of a v1alpha1 object, nil if there is none.
+func PlacementFromAnnotations(annotations map[string]string) (*Placement, error) {
+	s, ok := annotations[PlacementAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	var p Placement
+	if err := json.Unmarshal([]byte(s), &p); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %s", PlacementAnnotation, err)
+	}
+	return &p, nil
+}
+
+// kindOf returns the kind of a converted object, which is FlannelNetwork or
+// ClusterFlannelNetwork in both versions.
+func kindOf(kind string) string {
+	if kind == "" {
+		return v1alpha1.TPRFlannelKind
+	}
+	return kind
+}
+
+// annotationsWithout copies the annotations without the given keys, nil if
+// none are left.
+func annotationsWithout(annotations map[string]string, keys ...string) map[string]string {
+	out := map[string]string{}
+	for k, v := range annotations {
+		out[k] = v
+	}
+	for _, k := range keys {
+		delete(out, k)
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// ConvertFromV1alpha1 converts a v1alpha1 FlannelNetwork or
+// ClusterFlannelNetwork to v1beta1, keeping its kind. Values that don't
+// parse are kept in RawSpecAnnotation instead of failing the conversion.
+func ConvertFromV1alpha1(in *v1alpha1.FlannelNetwork) (*FlannelNetwork, error) {
+	in = in.DeepCopy()
+	out := &FlannelNetwork{
+		ObjectMeta: in.ObjectMeta,
+	}
+	out.Kind = kindOf(in.Kind)
+	out.APIVersion = TPRGroup + "/" + TPRVersion
+
+	// An invalid placement annotation stays as it is, validation
+	// reports it.
+	placement, err := PlacementFromAnnotations(in.Annotations)
+	if err == nil {
+		out.Annotations = annotationsWithout(in.Annotations, PlacementAnnotation, RawSpecAnnotation)
+		out.Spec.Placement = placement
+	}
+
+	var raw rawSpec
+	if in.Spec.VNI != "" {
+		vni, err := strconv.Atoi(in.Spec.VNI)
+		if err == nil {
+			out.Spec.VNI = vni
+		}
+		if err != nil || strconv.Itoa(vni) != in.Spec.VNI {
+			raw.VNI = in.Spec.VNI
+		}
+	}
+	if in.Spec.Cidr != "" {
+		cidr, err := ParseCIDR(in.Spec.Cidr)
+		if err == nil {
+			out.Spec.CIDR = cidr
+		}
+		if err != nil || cidr.String() != in.Spec.Cidr {
+			raw.Cidr = in.Spec.Cidr
+		}
+	}
+	out.Spec.MasqueradeExceptions = parseCIDRs(in.Spec.MasqueradeExceptions)
+	for i, e := range in.Spec.MasqueradeExceptions {
+		if i >= len(out.Spec.MasqueradeExceptions) || out.Spec.MasqueradeExceptions[i].String() != e {
+			raw.MasqueradeExceptions = in.Spec.MasqueradeExceptions
+			break
+		}
+	}
+	if raw.VNI != "" || raw.Cidr != "" || raw.MasqueradeExceptions != nil {
+		b, err := json.Marshal(raw)
+		if err != nil {
+			return nil, err
+		}
+		if out.Annotations == nil {
+			out.Annotations = map[string]string{}
+		}
+		out.Annotations[RawSpecAnnotation] = string(b)
+	}
+
+	out.Spec.Backend.Type = in.Spec.Backend
+	out.Spec.Peers = in.Spec.Peers
+	out.Spec.IPMasq = in.Spec.IPMasq
+	out.Spec.MTU = in.Spec.MTU
+	out.Spec.FlannelVersion = in.Spec.FlannelVersion
+	out.Spec.Paused = in.Spec.Paused
+	if r := in.Spec.Resources.DeepCopy(); r != nil {
+		resources := ResourceRequirements(*r)
+		out.Spec.Resources = &resources
+	}
+	if o := in.Spec.PodTemplateOverrides.DeepCopy(); o != nil {
+		overrides := PodTemplateOverrides(*o)
+		out.Spec.PodTemplateOverrides = &overrides
+	}
+
+	if in.Status != nil {
+		status := FlannelNetworkStatus(*in.Status)
+		out.Status = &status
+	}
+	return out, nil
+}
+
+// ConvertToV1alpha1 converts a v1beta1 FlannelNetwork or
+// ClusterFlannelNetwork to v1alpha1, keeping its kind.
+func ConvertToV1alpha1(in *FlannelNetwork) (*v1alpha1.FlannelNetwork, error) {
+	in = in.DeepCopy()
+	out := &v1alpha1.FlannelNetwork{
+		ObjectMeta: in.ObjectMeta,
+	}
+	out.Kind = kindOf(in.Kind)
+	out.APIVersion = TPRGroup + "/" + v1alpha1.TPRVersion
+
+	// spec.placement wins over a stale annotation.
+	out.Annotations = annotationsWithout(in.Annotations, PlacementAnnotation, RawSpecAnnotation)
+	if in.Spec.Placement != nil {
+		b, err := json.Marshal(in.Spec.Placement)
+		if err != nil {
+			return nil, err
+		}
+		if out.Annotations == nil {
+			out.Annotations = map[string]string{}
+		}
+		out.Annotations[PlacementAnnotation] = string(b)
+	}
+
+	if in.Spec.VNI != 0 {
+		out.Spec.VNI = strconv.Itoa(in.Spec.VNI)
+	}
+	out.Spec.Cidr = in.Spec.CIDR.String()
+	for _, e := range in.Spec.MasqueradeExceptions {
+		out.Spec.MasqueradeExceptions = append(out.Spec.MasqueradeExceptions, e.String())
+	}
+	restoreRawSpec(in, out)
+
+	out.Spec.Backend = in.Spec.Backend.Type
+	out.Spec.Peers = in.Spec.Peers
+	out.Spec.IPMasq = in.Spec.IPMasq
+	out.Spec.MTU = in.Spec.MTU
+	out.Spec.FlannelVersion = in.Spec.FlannelVersion
+	out.Spec.Paused = in.Spec.Paused
+	if r := in.Spec.DeepCopy().Resources; r != nil {
+		resources := v1alpha1.ResourceRequirements(*r)
+		out.Spec.Resources = &resources
+	}
+	if o := in.Spec.DeepCopy().PodTemplateOverrides; o != nil {
+		overrides := v1alpha1.PodTemplateOverrides(*o)
+		out.Spec.PodTemplateOverrides = &overrides
+	}
+
+	if in.Status != nil {
+		status := v1alpha1.FlannelNetworkStatus(*in.Status)
+		out.Status = &status
+	}
+	return out, nil
+}
+
+// parseCIDRs parses the CIDRs, skipping the ones that don't parse.
+func parseCIDRs(cidrs []string) []CIDR {
+	var out []CIDR
+	for _, s := range cidrs {
+		if cidr, err := ParseCIDR(s); err == nil {
+			out = append(out, cidr)
+		}
+	}
+	return out
+}
+
+// restoreRawSpec puts back the v1alpha1 strings kept in RawSpecAnnotation of
+// in, as far as they still convert to the typed fields of in. Fields changed
+// in v1beta1 keep their new value.
+func restoreRawSpec(in *FlannelNetwork, out *v1alpha1.FlannelNetwork) {
+	var raw rawSpec
+	if err := json.Unmarshal([]byte(in.Annotations[RawSpecAnnotation]), &raw); err != nil {
+		return
+	}
+
+	if raw.VNI != "" {
+		vni, err := strconv.Atoi(raw.VNI)
+		if (err != nil && in.Spec.VNI == 0) || (err == nil && vni == in.Spec.VNI) {
+			out.Spec.VNI = raw.VNI
+		}
+	}
+	if raw.Cidr != "" {
+		cidr, err := ParseCIDR(raw.Cidr)
+		if (err != nil && in.Spec.CIDR == CIDR{}) || (err == nil && cidr == in.Spec.CIDR) {
+			out.Spec.Cidr = raw.Cidr
+		}
+	}
+	if raw.MasqueradeExceptions != nil && reflect.DeepEqual(parseCIDRs(raw.MasqueradeExceptions), in.Spec.MasqueradeExceptions) {
+		out.Spec.MasqueradeExceptions = raw.MasqueradeExceptions
+	}
+}
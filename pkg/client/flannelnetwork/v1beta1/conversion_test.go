@@ -0,0 +1,124 @@
+package v1beta1
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1alpha1"
+
+	"k8s.io/client-go/1.5/pkg/api/v1"
+)
+
+func TestConvertRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		spec v1alpha1.FlannelNetworkSpec
+		// want is the v1beta1 spec, without the annotations.
+		want FlannelNetworkSpec
+	}{
+		{
+			name: "canonical",
+			spec: v1alpha1.FlannelNetworkSpec{VNI: "1", Cidr: "10.1.0.0/16", MasqueradeExceptions: []string{"10.0.0.0/8"}},
+			want: FlannelNetworkSpec{
+				VNI:                  1,
+				CIDR:                 CIDR{Address: "10.1.0.0", PrefixLength: 16},
+				MasqueradeExceptions: []CIDR{{Address: "10.0.0.0", PrefixLength: 8}},
+			},
+		},
+		{
+			name: "leading zero",
+			spec: v1alpha1.FlannelNetworkSpec{VNI: "01", Cidr: "10.1.0.0/16"},
+			want: FlannelNetworkSpec{VNI: 1, CIDR: CIDR{Address: "10.1.0.0", PrefixLength: 16}},
+		},
+		{
+			name: "legacy values that don't parse",
+			spec: v1alpha1.FlannelNetworkSpec{VNI: "one", Cidr: "10.1.0.0", MasqueradeExceptions: []string{"10.0.0.0/8", "any"}},
+			want: FlannelNetworkSpec{MasqueradeExceptions: []CIDR{{Address: "10.0.0.0", PrefixLength: 8}}},
+		},
+	}
+	for _, tt := range tests {
+		in := &v1alpha1.FlannelNetwork{
+			ObjectMeta: v1.ObjectMeta{Namespace: "tenant-a", Name: "web"},
+			Spec:       tt.spec,
+		}
+		beta, err := ConvertFromV1alpha1(in)
+		if err != nil {
+			t.Errorf("%s: ConvertFromV1alpha1: %s", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(beta.Spec, tt.want) {
+			t.Errorf("%s: got v1beta1 spec %+v, want %+v", tt.name, beta.Spec, tt.want)
+		}
+
+		alpha, err := ConvertToV1alpha1(beta)
+		if err != nil {
+			t.Errorf("%s: ConvertToV1alpha1: %s", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(alpha.Spec, tt.spec) {
+			t.Errorf("%s: got v1alpha1 spec %+v after the round trip, want %+v", tt.name, alpha.Spec, tt.spec)
+		}
+		if alpha.Annotations != nil {
+			t.Errorf("%s: got annotations %v after the round trip, want none", tt.name, alpha.Annotations)
+		}
+	}
+}
+
+func TestConvertRawSpecOfChangedFields(t *testing.T) {
+	in := &v1alpha1.FlannelNetwork{
+		Spec: v1alpha1.FlannelNetworkSpec{VNI: "01", Cidr: "10.1.0.0"},
+	}
+	beta, err := ConvertFromV1alpha1(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Values set in v1beta1 replace the kept ones.
+	beta.Spec.VNI = 2
+	beta.Spec.CIDR = CIDR{Address: "10.2.0.0", PrefixLength: 16}
+	alpha, err := ConvertToV1alpha1(beta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alpha.Spec.VNI != "2" || alpha.Spec.Cidr != "10.2.0.0/16" {
+		t.Errorf("got VNI %q and CIDR %q, want \"2\" and \"10.2.0.0/16\"", alpha.Spec.VNI, alpha.Spec.Cidr)
+	}
+}
+
+func TestConvertPlacement(t *testing.T) {
+	in := &FlannelNetwork{
+		ObjectMeta: v1.ObjectMeta{
+			Annotations: map[string]string{
+				PlacementAnnotation: `{"nodeSelector":{"zone":"old"}}`,
+				"team":              "a",
+			},
+		},
+		Spec: FlannelNetworkSpec{
+			VNI:       1,
+			Placement: &Placement{NodeSelector: map[string]string{"zone": "new"}},
+		},
+	}
+
+	alpha, err := ConvertToV1alpha1(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		PlacementAnnotation: `{"nodeSelector":{"zone":"new"}}`,
+		"team":              "a",
+	}
+	if !reflect.DeepEqual(alpha.Annotations, want) {
+		t.Errorf("got annotations %v, want %v", alpha.Annotations, want)
+	}
+
+	beta, err := ConvertFromV1alpha1(alpha)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(beta.Spec.Placement, in.Spec.Placement) {
+		t.Errorf("got placement %+v, want %+v", beta.Spec.Placement, in.Spec.Placement)
+	}
+	if !reflect.DeepEqual(beta.Annotations, map[string]string{"team": "a"}) {
+		t.Errorf("got annotations %v, want only the team", beta.Annotations)
+	}
+}
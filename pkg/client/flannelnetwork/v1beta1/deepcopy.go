@@ -0,0 +1,116 @@
+// The deep copies are written by hand, as deepcopy-gen output doesn't build
+// against client-go 1.5. Keep them in sync with types.go.
+
+package v1beta1
+
+import (
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/internal/deepcopy"
+
+	"k8s.io/client-go/1.5/pkg/runtime"
+)
+
+// DeepCopyInto copies the receiver into out. in must be non-nil.
+func (in *FlannelNetwork) DeepCopyInto(out *FlannelNetwork) {
+	out.TypeMeta = in.TypeMeta
+	deepcopy.ObjectMeta(&in.ObjectMeta, &out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	if in.Status != nil {
+		status := *in.Status
+		out.Status = &status
+	} else {
+		out.Status = nil
+	}
+}
+
+// DeepCopy returns a deep copy of the FlannelNetwork.
+func (in *FlannelNetwork) DeepCopy() *FlannelNetwork {
+	if in == nil {
+		return nil
+	}
+	out := new(FlannelNetwork)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject returns a deep copy as a runtime.Object.
+func (in *FlannelNetwork) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto copies the receiver into out. in must be non-nil.
+func (in *FlannelNetworkList) DeepCopyInto(out *FlannelNetworkList) {
+	out.TypeMeta = in.TypeMeta
+	out.ListMeta = in.ListMeta
+	if in.Items != nil {
+		out.Items = make([]*FlannelNetwork, len(in.Items))
+		for i := range in.Items {
+			out.Items[i] = in.Items[i].DeepCopy()
+		}
+	} else {
+		out.Items = nil
+	}
+}
+
+// DeepCopy returns a deep copy of the FlannelNetworkList.
+func (in *FlannelNetworkList) DeepCopy() *FlannelNetworkList {
+	if in == nil {
+		return nil
+	}
+	out := new(FlannelNetworkList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject returns a deep copy as a runtime.Object.
+func (in *FlannelNetworkList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto copies the receiver into out. in must be non-nil.
+func (in *FlannelNetworkSpec) DeepCopyInto(out *FlannelNetworkSpec) {
+	*out = *in
+	if in.Placement != nil {
+		out.Placement = &Placement{NodeSelector: deepcopy.StringMap(in.Placement.NodeSelector)}
+	}
+	out.Peers = deepcopy.Strings(in.Peers)
+	if in.IPMasq != nil {
+		ipMasq := *in.IPMasq
+		out.IPMasq = &ipMasq
+	}
+	if in.MasqueradeExceptions != nil {
+		out.MasqueradeExceptions = make([]CIDR, len(in.MasqueradeExceptions))
+		copy(out.MasqueradeExceptions, in.MasqueradeExceptions)
+	}
+	if in.Resources != nil {
+		out.Resources = &ResourceRequirements{
+			Requests: deepcopy.StringMap(in.Resources.Requests),
+			Limits:   deepcopy.StringMap(in.Resources.Limits),
+			QOSClass: in.Resources.QOSClass,
+		}
+	}
+	if in.PodTemplateOverrides != nil {
+		out.PodTemplateOverrides = &PodTemplateOverrides{
+			Labels:           deepcopy.StringMap(in.PodTemplateOverrides.Labels),
+			Annotations:      deepcopy.StringMap(in.PodTemplateOverrides.Annotations),
+			ImagePullSecrets: deepcopy.Strings(in.PodTemplateOverrides.ImagePullSecrets),
+			ImageRegistry:    in.PodTemplateOverrides.ImageRegistry,
+			Sidecars:         deepcopy.Containers(in.PodTemplateOverrides.Sidecars),
+		}
+	}
+}
+
+// DeepCopy returns a deep copy of the FlannelNetworkSpec.
+func (in *FlannelNetworkSpec) DeepCopy() *FlannelNetworkSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(FlannelNetworkSpec)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,40 @@
+package v1beta1
+
+import (
+	"k8s.io/client-go/1.5/pkg/api"
+	"k8s.io/client-go/1.5/pkg/api/unversioned"
+	"k8s.io/client-go/1.5/pkg/api/v1"
+	"k8s.io/client-go/1.5/pkg/runtime"
+)
+
+// SchemeGroupVersion is the group version the types are registered with.
+var SchemeGroupVersion = unversioned.GroupVersion{Group: TPRGroup, Version: TPRVersion}
+
+var (
+	SchemeBuilder = runtime.NewSchemeBuilder(addKnownTypes)
+	AddToScheme   = SchemeBuilder.AddToScheme
+)
+
+// The REST clients decode with api.Codecs, so the types are registered
+// with api.Scheme as well.
+func init() {
+	if err := AddToScheme(api.Scheme); err != nil {
+		panic(err)
+	}
+}
+
+// Resource takes an unqualified resource and returns a group qualified
+// GroupResource.
+func Resource(resource string) unversioned.GroupResource {
+	return SchemeGroupVersion.WithResource(resource).GroupResource()
+}
+
+func addKnownTypes(scheme *runtime.Scheme) error {
+	scheme.AddKnownTypes(SchemeGroupVersion,
+		&FlannelNetwork{},
+		&FlannelNetworkList{},
+		&v1.ListOptions{},
+		&v1.DeleteOptions{},
+	)
+	return nil
+}
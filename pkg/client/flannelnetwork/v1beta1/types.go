@@ -0,0 +1,146 @@
+// Package v1beta1 is the typed successor of the v1alpha1 FlannelNetwork
+// API. The operator works on v1alpha1, objects are converted between the
+// versions with the functions in conversion.go.
+package v1beta1
+
+import (
+	"k8s.io/client-go/1.5/pkg/api/unversioned"
+	"k8s.io/client-go/1.5/pkg/api/v1"
+
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1alpha1"
+)
+
+const (
+	TPRGroup   = v1alpha1.TPRGroup
+	TPRVersion = "v1beta1"
+)
+
+// FlannelNetwork is an overlay network with its own flannel client on every
+// node.
+type FlannelNetwork struct {
+	unversioned.TypeMeta `json:",inline"`
+	v1.ObjectMeta        `json:"metadata,omitempty"`
+	Spec                 FlannelNetworkSpec    `json:"spec"`
+	Status               *FlannelNetworkStatus `json:"status,omitempty"`
+}
+
+type FlannelNetworkList struct {
+	unversioned.TypeMeta `json:",inline"`
+	unversioned.ListMeta `json:"metadata,omitempty"`
+
+	Items []*FlannelNetwork `json:"items"`
+}
+
+type FlannelNetworkSpec struct {
+	// VNI is the VXLAN network identifier, in [1, 16777215].
+	VNI int `json:"vni,omitempty"`
+	// CIDR is the address range the node subnets are taken from.
+	CIDR CIDR `json:"cidr"`
+	// Backend configures how flannel encapsulates the traffic.
+	Backend Backend `json:"backend,omitempty"`
+	// Placement restricts the nodes the flannel client runs on.
+	Placement *Placement `json:"placement,omitempty"`
+
+	// Peers are the FlannelNetworks this network may open connections
+	// to, either by name within the same namespace or as
+	// <namespace>/<name>. Traffic to all other FlannelNetworks is dropped.
+	Peers []string `json:"peers,omitempty"`
+	// IPMasq tells whether traffic leaving the network is masqueraded.
+	// Defaults to the operator's -ip-masq flag.
+	IPMasq *bool `json:"ipMasq,omitempty"`
+	// MasqueradeExceptions are destination CIDRs that traffic leaving the
+	// network is not masqueraded for.
+	MasqueradeExceptions []CIDR `json:"masqueradeExceptions,omitempty"`
+	// MTU of the pod interfaces. Defaults to the operator's -mtu flag.
+	MTU int `json:"mtu,omitempty"`
+	// FlannelVersion is the flannel release the client of the network
+	// runs, e.g. v0.7.0. Defaults to the operator's -flannel-version flag.
+	FlannelVersion string `json:"flannelVersion,omitempty"`
+	// Paused freezes the objects managed for the network, e.g. during
+	// maintenance. Deleting the network still removes them.
+	Paused bool `json:"paused,omitempty"`
+	// Resources of the flannel client container. Defaults to the
+	// operator's -client-requests, -client-limits and -client-qos flags.
+	Resources *ResourceRequirements `json:"resources,omitempty"`
+	// PodTemplateOverrides are merged onto the pod template of the flannel
+	// client after the operator's -pod-template-overrides.
+	PodTemplateOverrides *PodTemplateOverrides `json:"podTemplateOverrides,omitempty"`
+}
+
+// PodTemplateOverrides are strategically merged onto the pod templates the
+// operator generates, e.g. to meet the policies of a cluster.
+type PodTemplateOverrides struct {
+	// Labels and Annotations are added to the pods. The labels the
+	// operator selects its pods by can't be overridden.
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// ImagePullSecrets name Secrets in kube-system to pull the images
+	// with.
+	ImagePullSecrets []string `json:"imagePullSecrets,omitempty"`
+	// ImageRegistry replaces the registry of all images, e.g. with a
+	// mirror like registry.example.com:5000.
+	ImageRegistry string `json:"imageRegistry,omitempty"`
+	// Sidecars are added to the pods. They can't be named like one of the
+	// operator's containers.
+	Sidecars []v1.Container `json:"sidecars,omitempty"`
+}
+
+// ResourceRequirements are the resources of a flannel container.
+type ResourceRequirements struct {
+	// Requests and Limits map cpu and memory to quantities, e.g.
+	// {"cpu": "100m", "memory": "50Mi"}.
+	Requests map[string]string `json:"requests,omitempty"`
+	Limits   map[string]string `json:"limits,omitempty"`
+	// QOSClass is the QoS class the pod is meant to get: Guaranteed,
+	// Burstable or BestEffort.
+	QOSClass string `json:"qosClass,omitempty"`
+}
+
+// CIDR is an IPv4 network.
+type CIDR struct {
+	// Address is the network address, e.g. 10.1.0.0.
+	Address string `json:"address"`
+	// PrefixLength is the number of network bits, e.g. 16.
+	PrefixLength int `json:"prefixLength"`
+}
+
+// Backend is a flannel backend.
+type Backend struct {
+	// Type of the backend. Only vxlan, the default, is supported.
+	Type string `json:"type,omitempty"`
+}
+
+// Placement selects the nodes of the flannel client.
+type Placement struct {
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+}
+
+type FlannelNetworkStatus struct {
+	// Paused mirrors spec.paused once the operator has seen it.
+	Paused bool `json:"paused"`
+	// Replicas is the number of non-terminated flannel client pods.
+	Replicas int32 `json:"replicas"`
+	// UpdatedReplicas is the number of flannel client pods of the current
+	// spec.
+	UpdatedReplicas int32 `json:"updatedReplicas"`
+	// AvailableReplicas is the number of flannel client pods ready for
+	// at least minReadySeconds.
+	AvailableReplicas int32 `json:"availableReplicas"`
+	// UnavailableReplicas is the number of flannel client pods that are
+	// not available.
+	UnavailableReplicas int32 `json:"unavailableReplicas"`
+	// Ready is true once all flannel client pods are up to date and have
+	// written the subnet lease of the network, see their readiness probe.
+	Ready bool `json:"ready"`
+	// DiagnosedNodes is the number of nodes the diagnostics agent
+	// reported from, AttachedNodes the number of those with the VXLAN
+	// device of the network.
+	DiagnosedNodes int32 `json:"diagnosedNodes,omitempty"`
+	AttachedNodes  int32 `json:"attachedNodes,omitempty"`
+	// DiagnosticsMessage lists the problems the diagnostics agents found
+	// with the network's devices, e.g. a device that is down.
+	DiagnosticsMessage string `json:"diagnosticsMessage,omitempty"`
+	// Message tells why the operator ignores the network, e.g. because
+	// an older network has the same VNI.
+	Message string `json:"message,omitempty"`
+}
@@ -0,0 +1,271 @@
+// Copyright 2017 Steffen Gebert
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cniselect is the flannel-select CNI plugin. The operator renders a
+// single conflist with it, sorting before the other CNI configs on the node,
+// as the kubelet only uses the first one. For every pod, the plugin looks up
+// the network the pod's namespace is bound to and hands the pod to the
+// flannel plugin with the config of that network. Pods of unbound namespaces
+// are handed to the next CNI config in the directory, i.e. the cluster
+// network.
+package cniselect
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/containernetworking/cni/libcni"
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/version"
+)
+
+// PluginType is the type of the plugin in the conflist.
+const PluginType = "flannel-select"
+
+// NetConf is the config of the plugin, as rendered by the operator.
+type NetConf struct {
+	types.NetConf
+
+	// Bindings maps namespaces to the name of the network their pods are
+	// attached to. Pods of other namespaces stay on the cluster network.
+	Bindings map[string]string `json:"bindings"`
+	// Networks holds the config of each network, handed to its plugin as
+	// is.
+	Networks map[string]json.RawMessage `json:"networks"`
+	// ConfDir is searched for the config of the cluster network.
+	ConfDir string `json:"confDir"`
+	// DataDir keeps the config every container was attached with, so it is
+	// detached the same way after its network changed or got deleted.
+	DataDir string `json:"dataDir"`
+}
+
+// attachment is what DataDir keeps for a container.
+type attachment struct {
+	// Network is empty for the cluster network.
+	Network string `json:"network"`
+	// Config is the config of the network, or the conflist of the cluster
+	// network.
+	Config json.RawMessage `json:"config"`
+}
+
+// Main runs the plugin.
+func Main() {
+	skel.PluginMain(cmdAdd, cmdDel, version.All)
+}
+
+func cmdAdd(args *skel.CmdArgs) error {
+	conf, err := loadNetConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	namespace := podNamespace(args.Args)
+	if name, ok := conf.Bindings[namespace]; ok {
+		netConf, ok := conf.Networks[name]
+		if !ok {
+			// Falling back to the cluster network would break the
+			// isolation of the namespace.
+			return fmt.Errorf("namespace %s is bound to unknown network %s", namespace, name)
+		}
+		// The attachment is written first, so a DEL after a failed ADD
+		// cleans up as well.
+		if err := saveAttachment(conf.DataDir, args.ContainerID, attachment{Network: name, Config: netConf}); err != nil {
+			return err
+		}
+		netType, err := pluginType(netConf)
+		if err != nil {
+			return fmt.Errorf("network %s: %s", name, err)
+		}
+		result, err := invoke.DelegateAdd(netType, netConf)
+		if err != nil {
+			return err
+		}
+		return printResult(result, conf.CNIVersion)
+	}
+
+	list, err := clusterNetwork(conf.ConfDir)
+	if err != nil {
+		return err
+	}
+	if err := saveAttachment(conf.DataDir, args.ContainerID, attachment{Config: list.Bytes}); err != nil {
+		return err
+	}
+	result, err := cniConfig().AddNetworkList(list, runtimeConf(args))
+	if err != nil {
+		return err
+	}
+	return printResult(result, conf.CNIVersion)
+}
+
+func cmdDel(args *skel.CmdArgs) error {
+	conf, err := loadNetConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	path := attachmentPath(conf.DataDir, args.ContainerID)
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		// Never attached, or already detached.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var a attachment
+	if err := json.Unmarshal(b, &a); err != nil {
+		return fmt.Errorf("decode %s: %s", path, err)
+	}
+
+	if a.Network != "" {
+		netType, err := pluginType(a.Config)
+		if err != nil {
+			return fmt.Errorf("network %s: %s", a.Network, err)
+		}
+		if err := invoke.DelegateDel(netType, a.Config); err != nil {
+			return err
+		}
+	} else {
+		list, err := libcni.ConfListFromBytes(a.Config)
+		if err != nil {
+			return err
+		}
+		if err := cniConfig().DelNetworkList(list, runtimeConf(args)); err != nil {
+			return err
+		}
+	}
+	return os.Remove(path)
+}
+
+func loadNetConf(b []byte) (*NetConf, error) {
+	var conf NetConf
+	if err := json.Unmarshal(b, &conf); err != nil {
+		return nil, fmt.Errorf("decode network config: %s", err)
+	}
+	if conf.ConfDir == "" || conf.DataDir == "" {
+		return nil, fmt.Errorf("confDir and dataDir must be set")
+	}
+	return &conf, nil
+}
+
+// podNamespace takes the namespace of the pod from the CNI_ARGS the kubelet
+// passes, e.g. IgnoreUnknown=1;K8S_POD_NAMESPACE=default;K8S_POD_NAME=web.
+func podNamespace(args string) string {
+	for _, kv := range parseArgs(args) {
+		if kv[0] == "K8S_POD_NAMESPACE" {
+			return kv[1]
+		}
+	}
+	return ""
+}
+
+func parseArgs(args string) [][2]string {
+	var pairs [][2]string
+	for _, kv := range strings.Split(args, ";") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		pairs = append(pairs, [2]string{parts[0], parts[1]})
+	}
+	return pairs
+}
+
+func pluginType(netConf []byte) (string, error) {
+	var conf types.NetConf
+	if err := json.Unmarshal(netConf, &conf); err != nil {
+		return "", err
+	}
+	if conf.Type == "" {
+		return "", fmt.Errorf("plugin type missing")
+	}
+	return conf.Type, nil
+}
+
+// clusterNetwork loads the first CNI config in dir that isn't one of the
+// plugin's own, in the order the kubelet would pick them.
+func clusterNetwork(dir string) (*libcni.NetworkConfigList, error) {
+	files, err := libcni.ConfFiles(dir, []string{".conf", ".conflist", ".json"})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		list, err := loadConfList(file)
+		if err != nil {
+			// The kubelet skips broken files as well.
+			continue
+		}
+		if len(list.Plugins) == 0 || list.Plugins[0].Network.Type == PluginType {
+			continue
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("no CNI config for the cluster network in %s", dir)
+}
+
+func loadConfList(file string) (*libcni.NetworkConfigList, error) {
+	if strings.HasSuffix(file, ".conflist") {
+		return libcni.ConfListFromFile(file)
+	}
+	conf, err := libcni.ConfFromFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return libcni.ConfListFromConf(conf)
+}
+
+func cniConfig() *libcni.CNIConfig {
+	return &libcni.CNIConfig{Path: filepath.SplitList(os.Getenv("CNI_PATH"))}
+}
+
+func runtimeConf(args *skel.CmdArgs) *libcni.RuntimeConf {
+	return &libcni.RuntimeConf{
+		ContainerID: args.ContainerID,
+		NetNS:       args.Netns,
+		IfName:      args.IfName,
+		Args:        parseArgs(args.Args),
+	}
+}
+
+func printResult(result types.Result, cniVersion string) error {
+	converted, err := result.GetAsVersion(cniVersion)
+	if err != nil {
+		return err
+	}
+	return converted.Print()
+}
+
+func attachmentPath(dataDir, containerID string) string {
+	return filepath.Join(dataDir, containerID)
+}
+
+func saveAttachment(dataDir, containerID string, a attachment) error {
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return err
+	}
+	b, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(attachmentPath(dataDir, containerID), b, 0600)
+}
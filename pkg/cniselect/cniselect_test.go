@@ -0,0 +1,108 @@
+// Copyright 2017 Steffen Gebert
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cniselect
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPodNamespace(t *testing.T) {
+	tests := []struct {
+		args string
+		want string
+	}{
+		{"IgnoreUnknown=1;K8S_POD_NAMESPACE=tenant-a;K8S_POD_NAME=web", "tenant-a"},
+		{"K8S_POD_NAMESPACE=default", "default"},
+		{"K8S_POD_NAME=web;broken", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := podNamespace(tt.args); got != tt.want {
+			t.Errorf("podNamespace(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestClusterNetwork(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{
+			name: "skips own conflist",
+			files: map[string]string{
+				"00-flannel.conflist": `{"cniVersion": "0.3.1", "name": "flannel", "plugins": [{"type": "flannel-select"}]}`,
+				"10-calico.conflist":  `{"cniVersion": "0.3.1", "name": "calico", "plugins": [{"type": "calico"}]}`,
+			},
+			want: "calico",
+		},
+		{
+			name: "takes the first in lexical order",
+			files: map[string]string{
+				"20-weave.conf":  `{"cniVersion": "0.3.1", "name": "weave", "type": "weave-net"}`,
+				"10-bridge.conf": `{"cniVersion": "0.3.1", "name": "bridge", "type": "bridge"}`,
+			},
+			want: "bridge",
+		},
+		{
+			name: "skips broken files",
+			files: map[string]string{
+				"05-broken.conf":      `{`,
+				"10-bridge.conf":      `{"cniVersion": "0.3.1", "name": "bridge", "type": "bridge"}`,
+				"README.md":           `not a config`,
+				"00-flannel.conflist": `{"cniVersion": "0.3.1", "name": "flannel", "plugins": [{"type": "flannel-select"}]}`,
+			},
+			want: "bridge",
+		},
+		{
+			name: "only own conflist",
+			files: map[string]string{
+				"00-flannel.conflist": `{"cniVersion": "0.3.1", "name": "flannel", "plugins": [{"type": "flannel-select"}]}`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		dir, err := ioutil.TempDir("", "cniselect")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		for name, content := range tt.files {
+			if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		list, err := clusterNetwork(dir)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s: got network %s, want an error", tt.name, list.Name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if list.Name != tt.want {
+			t.Errorf("%s: got network %s, want %s", tt.name, list.Name, tt.want)
+		}
+	}
+}
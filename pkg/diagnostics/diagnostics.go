@@ -0,0 +1,134 @@
+// Copyright 2017 Steffen Gebert
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package diagnostics collects the state of the flannel network devices of a
+// node. The agent runs next to the flannel-server on every node and serves
+// its report to the operator.
+package diagnostics
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Path is where the agent serves the NodeReport.
+	Path = "/diagnostics"
+
+	// devicePrefix is the prefix of the VXLAN devices flannel creates,
+	// followed by the VNI.
+	devicePrefix = "flannel."
+
+	sysClassNet = "/sys/class/net"
+)
+
+// NodeReport is the state of the flannel devices of a node.
+type NodeReport struct {
+	Node    string    `json:"node"`
+	Time    time.Time `json:"time"`
+	Devices []Device  `json:"devices"`
+}
+
+// Device is a flannel VXLAN device, one per network on the node.
+type Device struct {
+	Name string `json:"name"`
+	VNI  string `json:"vni"`
+	// OperState is the operational state from sysfs. VXLAN devices that
+	// work report "unknown".
+	OperState string `json:"operState"`
+	MTU       int    `json:"mtu"`
+	// Routes, FDB and Neighbors are the lines of ip route, bridge fdb
+	// and ip neigh for the device.
+	Routes    []string `json:"routes"`
+	FDB       []string `json:"fdb"`
+	Neighbors []string `json:"neighbors"`
+	// Errors are the parts of the state that couldn't be read.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Collect reads the state of all flannel devices of the node.
+func Collect(node string) NodeReport {
+	report := NodeReport{Node: node, Time: time.Now()}
+
+	entries, err := ioutil.ReadDir(sysClassNet)
+	if err != nil {
+		return report
+	}
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), devicePrefix) {
+			continue
+		}
+		report.Devices = append(report.Devices, collectDevice(e.Name()))
+	}
+	return report
+}
+
+func collectDevice(name string) Device {
+	d := Device{
+		Name: name,
+		VNI:  strings.TrimPrefix(name, devicePrefix),
+	}
+
+	if b, err := ioutil.ReadFile(filepath.Join(sysClassNet, name, "operstate")); err != nil {
+		d.Errors = append(d.Errors, err.Error())
+	} else {
+		d.OperState = strings.TrimSpace(string(b))
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(sysClassNet, name, "mtu")); err != nil {
+		d.Errors = append(d.Errors, err.Error())
+	} else {
+		d.MTU, _ = strconv.Atoi(strings.TrimSpace(string(b)))
+	}
+
+	var err error
+	if d.Routes, err = lines("ip", "route", "show", "dev", name); err != nil {
+		d.Errors = append(d.Errors, "routes: "+err.Error())
+	}
+	if d.FDB, err = lines("bridge", "fdb", "show", "dev", name); err != nil {
+		d.Errors = append(d.Errors, "fdb: "+err.Error())
+	}
+	if d.Neighbors, err = lines("ip", "neigh", "show", "dev", name); err != nil {
+		d.Errors = append(d.Errors, "neighbors: "+err.Error())
+	}
+	return d
+}
+
+// lines runs the command and returns the non-empty lines of its output.
+func lines(name string, args ...string) ([]string, error) {
+	out, err := exec.Command(name, args...).Output()
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, l := range strings.Split(string(out), "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			result = append(result, l)
+		}
+	}
+	return result, nil
+}
+
+// Handler serves the report of the node, collected on every request.
+func Handler(node string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Collect(node))
+	})
+}
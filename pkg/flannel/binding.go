@@ -0,0 +1,103 @@
+// Copyright 2017 Steffen Gebert
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flannel
+
+import (
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1alpha1"
+
+	"k8s.io/client-go/1.5/pkg/api/v1"
+	"k8s.io/client-go/1.5/pkg/labels"
+)
+
+// A namespace is bound to one of its FlannelNetworks by labelling it with
+// the network's name, e.g.
+//
+//	kubectl label namespace tenant-a flannel.st-g.de/network=flannel-network-1
+//
+// Namespaces without the label default to their only FlannelNetwork, if
+// they contain exactly one. Otherwise they are not bound at all and their
+// pods stay on the cluster network.
+const networkBindingLabel = v1alpha1.TPRGroup + "/network"
+
+// boundNetwork returns the name of the FlannelNetwork the namespace is bound
+// to, or "" if it is not bound. A labelled network need not exist.
+func (c *Operator) boundNetwork(ns *v1.Namespace) (string, error) {
+	if name, ok := ns.Labels[networkBindingLabel]; ok {
+		return name, nil
+	}
+
+	flans, err := c.flanLister.FlannelNetworks(ns.Name).List(labels.Everything())
+	if err != nil {
+		return "", err
+	}
+	if len(flans) == 1 {
+		return flans[0].Name, nil
+	}
+	return "", nil
+}
+
+// namespaceBindings maps the bound namespaces to the CNI network names of
+// their FlannelNetworks. Namespaces bound to a network that doesn't exist are
+// kept, so the CNI plugin refuses their pods instead of putting them on the
+// cluster network.
+func (c *Operator) namespaceBindings() (map[string]string, error) {
+	bindings := map[string]string{}
+	for _, obj := range c.nsInf.GetStore().List() {
+		ns := obj.(*v1.Namespace)
+		name, err := c.boundNetwork(ns)
+		if err != nil {
+			return nil, err
+		}
+		if name == "" {
+			continue
+		}
+		bindings[ns.Name] = cniNetworkName(&v1alpha1.FlannelNetwork{
+			ObjectMeta: v1.ObjectMeta{Namespace: ns.Name, Name: name},
+		})
+	}
+	return bindings, nil
+}
+
+func (c *Operator) handleAddNamespace(obj interface{}) {
+	c.syncBindings()
+}
+
+func (c *Operator) handleUpdateNamespace(old, cur interface{}) {
+	if old.(*v1.Namespace).Labels[networkBindingLabel] == cur.(*v1.Namespace).Labels[networkBindingLabel] {
+		return
+	}
+	log.Notice("Network binding of namespace", cur.(*v1.Namespace).Name, "changed")
+	c.syncBindings()
+}
+
+func (c *Operator) handleDeleteNamespace(obj interface{}) {
+	c.syncBindings()
+}
+
+// syncBindings renders the CNI config after a namespace got bound
+// differently.
+func (c *Operator) syncBindings() {
+	if !c.beginReconcile() {
+		return
+	}
+	defer c.inflight.Done()
+
+	if !c.cachesSynced() {
+		return
+	}
+	if err := c.syncCNIConfig(); err != nil {
+		log.Error("Syncing CNI config failed:", err)
+	}
+}
@@ -0,0 +1,372 @@
+// Copyright 2017 Steffen Gebert
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flannel
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"hash/fnv"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1alpha1"
+
+	"k8s.io/client-go/1.5/pkg/api/errors"
+	"k8s.io/client-go/1.5/pkg/api/v1"
+)
+
+const (
+	caSecretName     = "flannel-ca"
+	serverSecretName = "flannel-server-tls"
+	clientSecretName = "flannel-client-tls"
+	remoteTLSDir     = "/etc/flannel/remote-tls"
+
+	caValidity   = 10 * 365 * 24 * time.Hour
+	certValidity = 365 * 24 * time.Hour
+	// Certificates are renewed this long before they expire.
+	certRenewBefore = 30 * 24 * time.Hour
+	// certCheckInterval is how often the certificates are checked for
+	// renewal.
+	certCheckInterval = 1 * time.Hour
+
+	// tlsHashAnnotation on the pod templates identifies the certificate
+	// the pods run with, so renewed certificates are rolled out like any
+	// other change of the template.
+	tlsHashAnnotation = v1alpha1.TPRGroup + "/tls-hash"
+)
+
+// keyPair is a certificate along with its private key.
+type keyPair struct {
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+}
+
+func newSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func newCA() (*keyPair, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "flannel-ca"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &keyPair{cert: cert, key: key}, nil
+}
+
+// issue signs a new certificate for the given names with the CA. Names that
+// are IP addresses go into the IP SANs.
+func (ca *keyPair) issue(commonName string, names []string, usage x509.ExtKeyUsage) (*keyPair, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	var dnsNames []string
+	var ips []net.IP
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			ips = append(ips, ip)
+		} else {
+			dnsNames = append(dnsNames, name)
+		}
+	}
+
+	now := time.Now()
+	notAfter := now.Add(certValidity)
+	if notAfter.After(ca.cert.NotAfter) {
+		notAfter = ca.cert.NotAfter
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		IPAddresses:  ips,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &keyPair{cert: cert, key: key}, nil
+}
+
+func (kp *keyPair) certPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: kp.cert.Raw})
+}
+
+func (kp *keyPair) keyPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(kp.key)})
+}
+
+// keyPairFromSecret parses the tls.crt and tls.key of a Secret.
+func keyPairFromSecret(secret *v1.Secret) (*keyPair, error) {
+	certBlock, _ := pem.Decode(secret.Data[v1.TLSCertKey])
+	if certBlock == nil {
+		return nil, fmt.Errorf("no certificate in secret %s", secret.Name)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	keyBlock, _ := pem.Decode(secret.Data[v1.TLSPrivateKeyKey])
+	if keyBlock == nil {
+		return nil, fmt.Errorf("no private key in secret %s", secret.Name)
+	}
+	key, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return &keyPair{cert: cert, key: key}, nil
+}
+
+func tlsSecret(name string, kp, ca *keyPair) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      name,
+			Namespace: kubeSystemNamespace,
+		},
+		Type: v1.SecretTypeTLS,
+		Data: map[string][]byte{
+			"ca.crt":            ca.certPEM(),
+			v1.TLSCertKey:       kp.certPEM(),
+			v1.TLSPrivateKeyKey: kp.keyPEM(),
+		},
+	}
+}
+
+// needsRenewal tells whether the certificate expires soon or doesn't cover
+// all of the given names.
+func needsRenewal(cert *x509.Certificate, names []string) bool {
+	if time.Now().Add(certRenewBefore).After(cert.NotAfter) {
+		return true
+	}
+	for _, name := range names {
+		if cert.VerifyHostname(name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// ensureCA loads the operator's CA from its Secret, creating it on first
+// use. The CA itself is only replaced when it is about to expire, which
+// invalidates all certificates issued by it. In a dry run, a new CA is only
+// planned and nil is returned, as there is no CA to issue certificates with.
+func (c *Operator) ensureCA() (ca *keyPair, renewed bool, err error) {
+	secretClient := c.kclient.Core().Secrets(kubeSystemNamespace)
+
+	var existing *v1.Secret
+	secret, err := secretClient.Get(caSecretName)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, false, err
+	}
+	if err == nil {
+		existing = secret
+		ca, err = keyPairFromSecret(secret)
+		if err != nil {
+			return nil, false, err
+		}
+		if !needsRenewal(ca.cert, nil) {
+			return ca, false, nil
+		}
+		log.Notice("Renewing flannel CA")
+	}
+
+	if c.plan != nil {
+		verb := planCreate
+		if existing != nil {
+			verb = planUpdate
+		}
+		planned := map[string]string{
+			"ca.crt":            "<new CA>",
+			v1.TLSCertKey:       "<new CA>",
+			v1.TLSPrivateKeyKey: "<new key>",
+		}
+		c.dryRun(verb, "Secret", kubeSystemNamespace, caSecretName, redactedSecretData(existing), planned)
+		return nil, true, nil
+	}
+
+	ca, err = newCA()
+	if err != nil {
+		return nil, false, fmt.Errorf("create CA: %s", err)
+	}
+	if err := c.createOrUpdateSecret(tlsSecret(caSecretName, ca, ca)); err != nil {
+		return nil, false, fmt.Errorf("store CA: %s", err)
+	}
+	return ca, true, nil
+}
+
+// ensureCertificate issues the certificate stored in the named Secret if it
+// is missing, expires soon, doesn't cover names or wasn't signed by the
+// current CA. It returns whether a new certificate was issued.
+func (c *Operator) ensureCertificate(ca *keyPair, name, commonName string, names []string, usage x509.ExtKeyUsage) (bool, error) {
+	secret, err := c.kclient.Core().Secrets(kubeSystemNamespace).Get(name)
+	if err != nil && !errors.IsNotFound(err) {
+		return false, err
+	}
+	if err == nil {
+		kp, err := keyPairFromSecret(secret)
+		if err == nil && !needsRenewal(kp.cert, names) && kp.cert.CheckSignatureFrom(ca.cert) == nil {
+			return false, nil
+		}
+	}
+
+	log.Notice("Issuing certificate", name)
+	kp, err := ca.issue(commonName, names, usage)
+	if err != nil {
+		return false, fmt.Errorf("issue certificate %s: %s", name, err)
+	}
+	if err := c.createOrUpdateSecret(tlsSecret(name, kp, ca)); err != nil {
+		return false, fmt.Errorf("store certificate %s: %s", name, err)
+	}
+	return true, nil
+}
+
+// nodeNames returns the names of all nodes. The flannel clients connect to
+// the flannel-server by node name, so they all go into the server
+// certificate.
+func (c *Operator) nodeNames() []string {
+	var names []string
+	for _, obj := range c.nodeInf.GetStore().List() {
+		names = append(names, obj.(*v1.Node).Name)
+	}
+	return names
+}
+
+// syncCertificates makes sure the flannel-server and the flannel clients
+// have valid certificates. Renewed certificates only take effect once
+// flanneld restarts, so they are rolled out: the flannel-server node by node
+// by rolloutServer, the clients by their Deployments.
+func (c *Operator) syncCertificates() error {
+	// Issued from a partial cache, the server certificate would miss
+	// nodes.
+	if !c.nodeInf.HasSynced() {
+		return fmt.Errorf("nodes not synced yet")
+	}
+
+	ca, caRenewed, err := c.ensureCA()
+	if err != nil {
+		return err
+	}
+	if ca == nil {
+		// Dry run without a CA, the certificates issued with it would
+		// all be replaced once it is written.
+		return nil
+	}
+
+	serverRenewed, err := c.ensureCertificate(ca, serverSecretName, dsetFlannelName, c.nodeNames(), x509.ExtKeyUsageServerAuth)
+	if err != nil {
+		return err
+	}
+	clientRenewed, err := c.ensureCertificate(ca, clientSecretName, "flannel-client", nil, x509.ExtKeyUsageClientAuth)
+	if err != nil {
+		return err
+	}
+
+	if caRenewed && c.config.WebhookListenAddress != "" {
+		if err := c.issueWebhookCertificate(ca); err != nil {
+			return err
+		}
+	}
+	if caRenewed || serverRenewed {
+		c.triggerRollout()
+	}
+	if caRenewed || clientRenewed {
+		for _, flan := range c.networks() {
+			c.reconcileFlannelNetwork(flan)
+		}
+	}
+	return nil
+}
+
+// tlsHash identifies the certificate in the named Secret. It is empty
+// while the certificate isn't issued yet.
+func (c *Operator) tlsHash(secretName string) (string, error) {
+	secret, err := c.kclient.Core().Secrets(kubeSystemNamespace).Get(secretName)
+	if errors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("get secret %s: %s", secretName, err)
+	}
+	h := fnv.New32a()
+	h.Write(secret.Data["ca.crt"])
+	h.Write(secret.Data[v1.TLSCertKey])
+	return fmt.Sprintf("%08x", h.Sum32()), nil
+}
+
+// remoteTLSVolume is the Secret volume holding the flannel-server or
+// flannel-client certificate.
+func remoteTLSVolume(secretName string) (v1.Volume, v1.VolumeMount) {
+	return v1.Volume{
+		Name: "remote-tls",
+		VolumeSource: v1.VolumeSource{
+			Secret: &v1.SecretVolumeSource{
+				SecretName: secretName,
+			},
+		},
+	}, v1.VolumeMount{
+		Name:      "remote-tls",
+		MountPath: remoteTLSDir,
+		ReadOnly:  true,
+	}
+}
+
+// setRemoteTLS sets the flanneld flags to secure the connection between
+// the flannel clients and the flannel-server. Both sides use the same ones.
+func setRemoteTLS(o *flanneldOptions) {
+	o.RemoteCAFile = remoteTLSDir + "/ca.crt"
+	o.RemoteCertFile = remoteTLSDir + "/" + v1.TLSCertKey
+	o.RemoteKeyFile = remoteTLSDir + "/" + v1.TLSPrivateKeyKey
+}
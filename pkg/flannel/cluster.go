@@ -0,0 +1,101 @@
+// Copyright 2017 Steffen Gebert
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flannel
+
+import (
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1alpha1"
+)
+
+// ClusterFlannelNetworks are handled as FlannelNetworks with an empty
+// namespace, see v1alpha1.ClusterFlannelNetwork.AsFlannelNetwork. Their
+// networkKey is /<name>, which is also how peers refer to them.
+
+// clusterObjectPrefix replaces the namespace in the names of the objects
+// created for a ClusterFlannelNetwork.
+const clusterObjectPrefix = "cluster"
+
+func isClusterNetwork(flan *v1alpha1.FlannelNetwork) bool {
+	return flan.Namespace == ""
+}
+
+// networkKind is the kind of the network in logs and the dry-run plan.
+func networkKind(flan *v1alpha1.FlannelNetwork) string {
+	if isClusterNetwork(flan) {
+		return v1alpha1.TPRClusterFlannelKind
+	}
+	return v1alpha1.TPRFlannelKind
+}
+
+// objectPrefix is the namespace part of the names of the objects created for
+// the network.
+func objectPrefix(flan *v1alpha1.FlannelNetwork) string {
+	if isClusterNetwork(flan) {
+		return clusterObjectPrefix
+	}
+	return flan.Namespace
+}
+
+// networks returns the FlannelNetworks and ClusterFlannelNetworks known to
+// the informers. VNIs and CIDRs are allocated and checked for conflicts
+// across both kinds. The FlannelNetworks are shared with the informer cache
+// and must not be modified.
+func (c *Operator) networks() []*v1alpha1.FlannelNetwork {
+	var flans []*v1alpha1.FlannelNetwork
+	for _, obj := range c.flanInf.GetStore().List() {
+		flans = append(flans, obj.(*v1alpha1.FlannelNetwork))
+	}
+	for _, obj := range c.clusterInf.GetStore().List() {
+		flans = append(flans, obj.(*v1alpha1.ClusterFlannelNetwork).AsFlannelNetwork())
+	}
+	return flans
+}
+
+// networksSynced tells whether both informers have synced.
+func (c *Operator) networksSynced() bool {
+	return c.flanInf.HasSynced() && c.clusterInf.HasSynced()
+}
+
+func (c *Operator) handleAddClusterFlannelNetwork(obj interface{}) {
+	c.handleAddFlannelNetwork(obj.(*v1alpha1.ClusterFlannelNetwork).AsFlannelNetwork())
+}
+
+func (c *Operator) handleUpdateClusterFlannelNetwork(old, cur interface{}) {
+	c.handleUpdateFlannelNetwork(
+		old.(*v1alpha1.ClusterFlannelNetwork).AsFlannelNetwork(),
+		cur.(*v1alpha1.ClusterFlannelNetwork).AsFlannelNetwork(),
+	)
+}
+
+func (c *Operator) handleDeleteClusterFlannelNetwork(obj interface{}) {
+	c.handleDeleteFlannelNetwork(obj.(*v1alpha1.ClusterFlannelNetwork).AsFlannelNetwork())
+}
+
+// updateNetworkStatus writes the status of a network of either kind.
+func (c *Operator) updateNetworkStatus(flan *v1alpha1.FlannelNetwork) error {
+	if isClusterNetwork(flan) {
+		_, err := c.fclient.ClusterFlannelNetworks().UpdateStatus(v1alpha1.ClusterFlannelNetworkFrom(flan))
+		return err
+	}
+	_, err := c.fclient.FlannelNetworks(flan.Namespace).UpdateStatus(flan)
+	return err
+}
+
+// deleteNetwork deletes a network of either kind.
+func (c *Operator) deleteNetwork(flan *v1alpha1.FlannelNetwork) error {
+	if isClusterNetwork(flan) {
+		return c.fclient.ClusterFlannelNetworks().Delete(flan.Name, nil)
+	}
+	return c.fclient.FlannelNetworks(flan.Namespace).Delete(flan.Name, nil)
+}
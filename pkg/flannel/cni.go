@@ -0,0 +1,348 @@
+// Copyright 2017 Steffen Gebert
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flannel
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/containernetworking/cni/pkg/types"
+
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1alpha1"
+	"github.com/StephenKing/flannel-operator/pkg/cniselect"
+
+	"k8s.io/client-go/1.5/pkg/api/errors"
+	"k8s.io/client-go/1.5/pkg/api/unversioned"
+	"k8s.io/client-go/1.5/pkg/api/v1"
+	"k8s.io/client-go/1.5/pkg/apis/extensions/v1beta1"
+)
+
+const (
+	cniConfigMapName = "flannel-cni-config"
+	cniInstallerName = "flannel-cni-installer"
+	cniVersion       = "0.3.1"
+	cniConfDir       = "/etc/cni/net.d"
+	cniBinDir        = "/opt/cni/bin"
+	// cniConfigFileName sorts before the usual CNI configs, as the kubelet
+	// only uses the first one.
+	cniConfigFileName = "00-flannel.conflist"
+	cniDataDir        = "/var/lib/cni/" + cniselect.PluginType
+
+	defaultCNIPluginImage = "stephenking/flannel-operator"
+
+	// defaultMTU leaves room for the 50 bytes of VXLAN encapsulation on a
+	// 1500 byte underlay.
+	defaultMTU = 1450
+)
+
+// cniInstallScript keeps the flannel-select plugin and the conflist on the
+// host in sync with the ConfigMap. Without networks both are removed, so the
+// kubelet falls back to the config of the cluster network and nothing of the
+// operator is left on the nodes once it is uninstalled.
+const cniInstallScript = `
+bin=/host` + cniBinDir + `/` + cniselect.PluginType + `
+src=/etc/flannel-cni/` + cniConfigFileName + `
+dst=/host` + cniConfDir + `/` + cniConfigFileName + `
+while true; do
+  if [ -e $src ]; then
+    cmp -s /bin/` + cniselect.PluginType + ` $bin || {
+      cp /bin/` + cniselect.PluginType + ` /host` + cniBinDir + `/.` + cniselect.PluginType + ` &&
+        mv -f /host` + cniBinDir + `/.` + cniselect.PluginType + ` $bin || exit 1
+    }
+    cmp -s $src $dst || { cp $src $dst.tmp && mv -f $dst.tmp $dst; }
+  else
+    rm -f $dst $bin
+  fi
+  sleep 10
+done
+`
+
+// cniInstallerTolerations let the installer run on nodes that are not ready
+// because they lack a CNI config. The typed client predates
+// spec.tolerations, so they are patched onto the DaemonSet.
+var cniInstallerTolerations = []map[string]string{
+	{"key": "CriticalAddonsOnly", "operator": "Exists"},
+	{"key": "node.kubernetes.io/not-ready", "operator": "Exists"},
+}
+
+type cniNetConfList struct {
+	CNIVersion string              `json:"cniVersion"`
+	Name       string              `json:"name"`
+	Plugins    []cniselect.NetConf `json:"plugins"`
+}
+
+// cniFlannelConf configures the flannel CNI plugin. It reads the subnet
+// file written by the flannel client and hands the delegate config on to
+// the bridge plugin, filling in host-local IPAM for the node's subnet.
+type cniFlannelConf struct {
+	CNIVersion string          `json:"cniVersion"`
+	Name       string          `json:"name"`
+	Type       string          `json:"type"`
+	SubnetFile string          `json:"subnetFile"`
+	DataDir    string          `json:"dataDir"`
+	Delegate   cniDelegateConf `json:"delegate"`
+}
+
+type cniDelegateConf struct {
+	Type             string `json:"type"`
+	Bridge           string `json:"bridge"`
+	MTU              int    `json:"mtu"`
+	IsDefaultGateway bool   `json:"isDefaultGateway"`
+}
+
+// cniNetworkName is the name of the CNI network of a FlannelNetwork.
+func cniNetworkName(flan *v1alpha1.FlannelNetwork) string {
+	return objectPrefix(flan) + "-" + flan.Name
+}
+
+// renderCNIConfig renders the config of the network the flannel-select
+// plugin hands to the flannel plugin.
+func renderCNIConfig(flan *v1alpha1.FlannelNetwork, mtu int) ([]byte, error) {
+	conf := cniFlannelConf{
+		CNIVersion: cniVersion,
+		Name:       cniNetworkName(flan),
+		Type:       "flannel",
+		SubnetFile: subnetFilePath(flan),
+		DataDir:    "/var/lib/cni/flannel/" + cniNetworkName(flan),
+		Delegate: cniDelegateConf{
+			Type: "bridge",
+			// Interface names are limited to 15 characters, so the
+			// network name can't be used here.
+			Bridge:           "cni" + flan.Spec.VNI,
+			MTU:              mtu,
+			IsDefaultGateway: true,
+		},
+	}
+	return json.Marshal(conf)
+}
+
+// renderCNIConfigList renders the conflist of the flannel-select plugin with
+// the configs of the networks and the namespaces bound to them.
+func renderCNIConfigList(networks map[string]json.RawMessage, bindings map[string]string) ([]byte, error) {
+	conf := cniNetConfList{
+		CNIVersion: cniVersion,
+		Name:       "flannel",
+		Plugins: []cniselect.NetConf{
+			{
+				NetConf:  types.NetConf{Type: cniselect.PluginType},
+				Bindings: bindings,
+				Networks: networks,
+				ConfDir:  cniConfDir,
+				DataDir:  cniDataDir,
+			},
+		},
+	}
+	return json.MarshalIndent(conf, "", "  ")
+}
+
+// renderedCNINetworks returns the network configs in the conflist of the
+// ConfigMap, if any.
+func renderedCNINetworks(cm *v1.ConfigMap) map[string]json.RawMessage {
+	if cm == nil {
+		return nil
+	}
+	var conf cniNetConfList
+	if err := json.Unmarshal([]byte(cm.Data[cniConfigFileName]), &conf); err != nil || len(conf.Plugins) == 0 {
+		return nil
+	}
+	return conf.Plugins[0].Networks
+}
+
+// syncCNIConfig renders the conflist with all known FlannelNetworks and
+// namespace bindings into the ConfigMap the installer DaemonSet distributes
+// to the nodes.
+func (c *Operator) syncCNIConfig() error {
+	cm := &v1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      cniConfigMapName,
+			Namespace: kubeSystemNamespace,
+			Labels: map[string]string{
+				"app": cniInstallerName,
+			},
+		},
+		Data: map[string]string{},
+	}
+
+	existing, err := c.kclient.Core().ConfigMaps(kubeSystemNamespace).Get(cniConfigMapName)
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("get CNI ConfigMap: %s", err)
+	}
+	if err != nil {
+		existing = nil
+	}
+	rendered := renderedCNINetworks(existing)
+
+	networks := map[string]json.RawMessage{}
+	for _, flan := range c.networks() {
+		name := cniNetworkName(flan)
+		if flan.Spec.Paused {
+			// Keep whatever was rendered before the network got paused.
+			if conf, ok := rendered[name]; ok {
+				networks[name] = conf
+			}
+			continue
+		}
+		if c.checkNetwork(flan) != nil {
+			continue
+		}
+		b, err := renderCNIConfig(flan, c.mtu(flan))
+		if err != nil {
+			return fmt.Errorf("render CNI config of %s: %s", networkKey(flan), err)
+		}
+		networks[name] = b
+	}
+
+	bindings, err := c.namespaceBindings()
+	if err != nil {
+		return fmt.Errorf("resolve namespace bindings: %s", err)
+	}
+
+	if len(networks) > 0 || len(bindings) > 0 {
+		b, err := renderCNIConfigList(networks, bindings)
+		if err != nil {
+			return fmt.Errorf("render CNI conflist: %s", err)
+		}
+		cm.Data[cniConfigFileName] = string(b)
+	}
+
+	if err := c.createOrUpdateConfigMap(cm); err != nil {
+		return fmt.Errorf("update CNI ConfigMap: %s", err)
+	}
+	return nil
+}
+
+func (c *Operator) createCNIInstallerDaemonSet() error {
+	log.Notice("Creating DaemonSet for", cniInstallerName)
+
+	// The installer pods can't start without their ConfigMap. It is filled
+	// as soon as the first FlannelNetwork shows up.
+	cm := &v1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      cniConfigMapName,
+			Namespace: kubeSystemNamespace,
+			Labels: map[string]string{
+				"app": cniInstallerName,
+			},
+		},
+	}
+	if err := c.createConfigMapIfNotExists(cm); err != nil {
+		return fmt.Errorf("create configmap %s: %s", cniConfigMapName, err)
+	}
+
+	daemonSet := &v1beta1.DaemonSet{
+		TypeMeta: unversioned.TypeMeta{
+			Kind:       "DaemonSet",
+			APIVersion: "extensions/v1beta1",
+		},
+		ObjectMeta: v1.ObjectMeta{
+			Namespace: kubeSystemNamespace,
+			Name:      cniInstallerName,
+			Labels: map[string]string{
+				"app": cniInstallerName,
+			},
+		},
+		Spec: v1beta1.DaemonSetSpec{
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: v1.ObjectMeta{
+					// The installer has to run wherever the
+					// flannel-server runs.
+					Annotations: map[string]string{
+						"scheduler.alpha.kubernetes.io/critical-pod": "",
+						"scheduler.alpha.kubernetes.io/tolerations":  "[{\"key\":\"CriticalAddonsOnly\", \"operator\":\"Exists\"}]",
+					},
+					Labels: map[string]string{
+						"app": cniInstallerName,
+					},
+				},
+				Spec: v1.PodSpec{
+					// The installer only writes to the host, and a node
+					// has no pod network before it.
+					HostNetwork: true,
+					Containers: []v1.Container{
+						{
+							Name:    "install-cni",
+							Image:   c.config.CNIPluginImage,
+							Command: []string{"/bin/sh", "-c", cniInstallScript},
+							VolumeMounts: []v1.VolumeMount{
+								{
+									Name:      "cni-config",
+									MountPath: "/etc/flannel-cni",
+								},
+								{
+									Name:      "cni-net-dir",
+									MountPath: "/host" + cniConfDir,
+								},
+								{
+									Name:      "cni-bin-dir",
+									MountPath: "/host" + cniBinDir,
+								},
+							},
+						},
+					},
+					Volumes: []v1.Volume{
+						{
+							Name: "cni-config",
+							VolumeSource: v1.VolumeSource{
+								ConfigMap: &v1.ConfigMapVolumeSource{
+									LocalObjectReference: v1.LocalObjectReference{
+										Name: cniConfigMapName,
+									},
+								},
+							},
+						}, {
+							Name: "cni-net-dir",
+							VolumeSource: v1.VolumeSource{
+								HostPath: &v1.HostPathVolumeSource{
+									Path: cniConfDir,
+								},
+							},
+						}, {
+							Name: "cni-bin-dir",
+							VolumeSource: v1.VolumeSource{
+								HostPath: &v1.HostPathVolumeSource{
+									Path: cniBinDir,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if err := applyPodTemplateOverrides(&daemonSet.Spec.Template, c.config.PodTemplateOverrides); err != nil {
+		return err
+	}
+	if err := c.createOrUpdateDaemonSet(daemonSet); err != nil {
+		return fmt.Errorf("create daemonset %s: %s", cniInstallerName, err)
+	}
+
+	patch := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"tolerations": cniInstallerTolerations,
+				},
+			},
+		},
+	}
+	path := "/apis/extensions/v1beta1/namespaces/" + kubeSystemNamespace + "/daemonsets"
+	if err := c.patchRaw("DaemonSet", path, kubeSystemNamespace, cniInstallerName, patch); err != nil {
+		return err
+	}
+
+	log.Notice("DaemonSet created:", cniInstallerName)
+	return nil
+}
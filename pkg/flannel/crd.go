@@ -0,0 +1,170 @@
+// Copyright 2017 Steffen Gebert
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flannel
+
+import (
+	"fmt"
+
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1alpha1"
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1beta1"
+
+	"k8s.io/client-go/1.5/pkg/api/errors"
+)
+
+// The client-go release the operator is built with predates
+// CustomResourceDefinitions, so they are registered through the raw REST
+// client, see createOrPatchRaw.
+
+const crdPath = "/apis/apiextensions.k8s.io/v1beta1/customresourcedefinitions"
+
+// conversionKubeMinor is the first Kubernetes 1.x release with the webhook
+// conversion of CRDs and the matchPolicy of admission webhooks enabled by
+// default.
+const conversionKubeMinor = 15
+
+// customResourceDefinition is the apiextensions.k8s.io/v1beta1
+// CustomResourceDefinition, reduced to the fields used here.
+type customResourceDefinition struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   objectMeta `json:"metadata"`
+	Spec       crdSpec    `json:"spec"`
+}
+
+type objectMeta struct {
+	Name string `json:"name"`
+}
+
+type crdSpec struct {
+	Group string `json:"group"`
+	// Version is the storage version, which has to be set along with
+	// Versions until apiextensions.k8s.io/v1.
+	Version    string         `json:"version"`
+	Versions   []crdVersion   `json:"versions"`
+	Scope      string         `json:"scope"`
+	Names      crdNames       `json:"names"`
+	Conversion *crdConversion `json:"conversion"`
+}
+
+type crdVersion struct {
+	Name    string `json:"name"`
+	Served  bool   `json:"served"`
+	Storage bool   `json:"storage"`
+}
+
+// crdConversion has the apiserver convert between the versions with the
+// conversion webhook on /convert.
+// The fields are sent as null without a webhook, so merge patches remove
+// them.
+type crdConversion struct {
+	Strategy                 string               `json:"strategy"`
+	WebhookClientConfig      *webhookClientConfig `json:"webhookClientConfig"`
+	ConversionReviewVersions []string             `json:"conversionReviewVersions"`
+}
+
+type crdNames struct {
+	Plural   string `json:"plural"`
+	Singular string `json:"singular"`
+	Kind     string `json:"kind"`
+	ListKind string `json:"listKind"`
+}
+
+// newCRD serves v1alpha1, the version the objects are stored and worked on
+// in. With a conversion webhook, v1beta1 is served as well.
+func newCRD(plural, singular, kind, scope string, conversion *crdConversion) customResourceDefinition {
+	crd := customResourceDefinition{
+		APIVersion: "apiextensions.k8s.io/v1beta1",
+		Kind:       "CustomResourceDefinition",
+		Metadata:   objectMeta{Name: plural + "." + v1alpha1.TPRGroup},
+		Spec: crdSpec{
+			Group:   v1alpha1.TPRGroup,
+			Version: v1alpha1.TPRVersion,
+			Versions: []crdVersion{
+				{Name: v1alpha1.TPRVersion, Served: true, Storage: true},
+			},
+			Scope: scope,
+			Names: crdNames{
+				Plural:   plural,
+				Singular: singular,
+				Kind:     kind,
+				ListKind: kind + "List",
+			},
+			Conversion: &crdConversion{Strategy: "None"},
+		},
+	}
+	if conversion != nil {
+		crd.Spec.Versions = append(crd.Spec.Versions, crdVersion{Name: v1beta1.TPRVersion, Served: true})
+		crd.Spec.Conversion = conversion
+	}
+	return crd
+}
+
+// customResourceDefinitions are the CRDs the networks of both kinds are
+// served by. v1beta1 is only served with the webhooks enabled, caBundle, the
+// CA the apiserver trusts the conversion webhook by, known, and an apiserver
+// of conversionKubeMinor or later.
+func (c *Operator) customResourceDefinitions(caBundle []byte) []customResourceDefinition {
+	var conversion *crdConversion
+	if c.config.WebhookListenAddress != "" && len(caBundle) > 0 && c.kubeMinor >= conversionKubeMinor {
+		config := c.webhookClientConfigFor("/convert", caBundle)
+		conversion = &crdConversion{
+			Strategy:                 "Webhook",
+			WebhookClientConfig:      &config,
+			ConversionReviewVersions: []string{"v1beta1"},
+		}
+	}
+	return []customResourceDefinition{
+		newCRD(v1alpha1.TPRFlannelName, "flannelnetwork", v1alpha1.TPRFlannelKind, "Namespaced", conversion),
+		// Creating cluster-scoped objects takes a ClusterRole, so
+		// tenants can't declare networks for the whole cluster.
+		newCRD(v1alpha1.TPRClusterFlannelName, "clusterflannelnetwork", v1alpha1.TPRClusterFlannelKind, "Cluster", conversion),
+	}
+}
+
+// caBundle returns the certificate of the operator's CA, nil if there is
+// none yet.
+func (c *Operator) caBundle() ([]byte, error) {
+	secret, err := c.kclient.Core().Secrets(kubeSystemNamespace).Get(caSecretName)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get CA: %s", err)
+	}
+	return secret.Data["ca.crt"], nil
+}
+
+// createCRDs registers the CRDs or brings the spec of existing ones up to
+// date, with the conversion webhook trusted by caBundle.
+func (c *Operator) createCRDs(caBundle []byte) error {
+	for _, crd := range c.customResourceDefinitions(caBundle) {
+		patch := map[string]interface{}{"spec": crd.Spec}
+		if err := c.createOrPatchRaw("CustomResourceDefinition", crdPath, crd.Metadata.Name, crd, patch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteCRDs removes the CRDs along with all networks left.
+func (c *Operator) deleteCRDs() error {
+	for _, crd := range c.customResourceDefinitions(nil) {
+		log.Notice("Deleting CRD", crd.Metadata.Name)
+		if err := c.deleteRaw("CustomResourceDefinition", crdPath, crd.Metadata.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,343 @@
+// Copyright 2017 Steffen Gebert
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flannel
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	etcd "github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1alpha1"
+
+	"k8s.io/client-go/1.5/pkg/api/errors"
+	"k8s.io/client-go/1.5/pkg/api/v1"
+	"k8s.io/client-go/1.5/pkg/apis/extensions/v1beta1"
+)
+
+// Datastores flanneld can keep its network configs and subnet leases in.
+const (
+	// DatastoreEtcd has the flannel-server DaemonSet serve leases out of
+	// etcd to the flannel clients.
+	DatastoreEtcd = "etcd"
+	// DatastoreKubernetes has the flannel clients keep their leases in
+	// annotations of the nodes and read the network config from a
+	// ConfigMap. There is no flannel-server then.
+	DatastoreKubernetes = "kubernetes"
+)
+
+const (
+	defaultEtcdPrefix = "/coreos.com/network"
+	etcdTimeout       = 5 * time.Second
+
+	netConfConfigMapName = "flannel-net-conf"
+	netConfDir           = "/etc/kube-flannel"
+
+	// kubeFlannelImage is the upstream image, the one of the flannel-server
+	// doesn't come with --kube-subnet-mgr.
+	kubeFlannelImage = "quay.io/coreos/flannel"
+	// kubeMinVersion is the first flannel release reading the network
+	// config from --net-config-path.
+	kubeMinVersion = "v0.10.0"
+)
+
+// networkConfig is the flannel network config of a FlannelNetwork.
+type networkConfig struct {
+	Network string        `json:"Network"`
+	Backend backendConfig `json:"Backend"`
+}
+
+type backendConfig struct {
+	Type string `json:"Type"`
+	VNI  int    `json:"VNI"`
+}
+
+func renderNetworkConfig(flan *v1alpha1.FlannelNetwork) ([]byte, error) {
+	vni, err := strconv.Atoi(flan.Spec.VNI)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VNI %q: %s", flan.Spec.VNI, err)
+	}
+	return json.Marshal(networkConfig{
+		Network: flan.Spec.Cidr,
+		Backend: backendConfig{
+			Type: backendType(flan),
+			VNI:  vni,
+		},
+	})
+}
+
+// networkConfigKey is the name flanneld knows the network config by. The
+// clients are started with --networks=<vni>. flanneld takes it as a string,
+// so the VNI is in canonical form, the same for 01 and 1.
+func networkConfigKey(flan *v1alpha1.FlannelNetwork) string {
+	return v1alpha1.CanonicalVNI(flan.Spec.VNI)
+}
+
+// networkStore puts the network configs where flanneld reads them from.
+type networkStore interface {
+	PutNetworkConfig(flan *v1alpha1.FlannelNetwork) error
+	DeleteNetworkConfig(flan *v1alpha1.FlannelNetwork) error
+}
+
+// newNetworkStore returns the networkStore of the configured datastore.
+func (c *Operator) newNetworkStore() (networkStore, error) {
+	if c.config.Datastore == DatastoreKubernetes {
+		return &configMapNetworkStore{c: c}, nil
+	}
+
+	if len(c.config.EtcdEndpoints) == 0 {
+		// The default endpoints are node local and out of reach for the
+		// operator, so the configs are left to whoever set up etcd.
+		log.Warning("No etcd endpoints configured, not managing flannel network configs in etcd")
+		return nopNetworkStore{}, nil
+	}
+
+	prefix := c.config.EtcdPrefix
+	if prefix == "" {
+		prefix = defaultEtcdPrefix
+	}
+
+	if c.plan != nil {
+		return &dryRunEtcdStore{c: c, prefix: prefix}, nil
+	}
+
+	transport := &http.Transport{}
+	if c.config.EtcdTLSSecret != "" {
+		tlsConfig, err := c.etcdTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	client, err := etcd.New(etcd.Config{
+		Endpoints: c.config.EtcdEndpoints,
+		Transport: transport,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create etcd client: %s", err)
+	}
+
+	return &etcdNetworkStore{kapi: etcd.NewKeysAPI(client), prefix: prefix}, nil
+}
+
+// etcdTLSConfig reads the etcd client certificate out of the Secret that is
+// also mounted into the flannel-server pods.
+func (c *Operator) etcdTLSConfig() (*tls.Config, error) {
+	secret, err := c.kclient.Core().Secrets(kubeSystemNamespace).Get(c.config.EtcdTLSSecret)
+	if err != nil {
+		return nil, fmt.Errorf("get etcd TLS secret: %s", err)
+	}
+
+	cert, err := tls.X509KeyPair(secret.Data["tls.crt"], secret.Data["tls.key"])
+	if err != nil {
+		return nil, fmt.Errorf("load etcd client certificate: %s", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(secret.Data["ca.crt"]) {
+		return nil, fmt.Errorf("no CA certificates in etcd TLS secret")
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+	}, nil
+}
+
+type nopNetworkStore struct{}
+
+func (nopNetworkStore) PutNetworkConfig(*v1alpha1.FlannelNetwork) error    { return nil }
+func (nopNetworkStore) DeleteNetworkConfig(*v1alpha1.FlannelNetwork) error { return nil }
+
+// etcdNetworkStore writes the configs to <prefix>/<vni>/config, where the
+// flannel-server looks them up.
+type etcdNetworkStore struct {
+	kapi   etcd.KeysAPI
+	prefix string
+}
+
+func (s *etcdNetworkStore) PutNetworkConfig(flan *v1alpha1.FlannelNetwork) error {
+	b, err := renderNetworkConfig(flan)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+
+	_, err = s.kapi.Set(ctx, s.prefix+"/"+networkConfigKey(flan)+"/config", string(b), nil)
+	return err
+}
+
+func (s *etcdNetworkStore) DeleteNetworkConfig(flan *v1alpha1.FlannelNetwork) error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+
+	// The subnet leases of the network go away along with the config.
+	_, err := s.kapi.Delete(ctx, s.prefix+"/"+networkConfigKey(flan), &etcd.DeleteOptions{Recursive: true})
+	if etcd.IsKeyNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// configMapNetworkStore keeps the configs in a ConfigMap in kube-system that
+// is mounted into the flannel clients.
+type configMapNetworkStore struct {
+	c *Operator
+}
+
+func (s *configMapNetworkStore) update(mutate func(data map[string]string)) error {
+	cmClient := s.c.kclient.Core().ConfigMaps(kubeSystemNamespace)
+
+	cm, err := cmClient.Get(netConfConfigMapName)
+	if errors.IsNotFound(err) {
+		cm = &v1.ConfigMap{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      netConfConfigMapName,
+				Namespace: kubeSystemNamespace,
+				Labels: map[string]string{
+					"app": "flannel-client",
+				},
+			},
+		}
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+
+	mutate(cm.Data)
+	return s.c.createOrUpdateConfigMap(cm)
+}
+
+func (s *configMapNetworkStore) PutNetworkConfig(flan *v1alpha1.FlannelNetwork) error {
+	b, err := renderNetworkConfig(flan)
+	if err != nil {
+		return err
+	}
+	return s.update(func(data map[string]string) {
+		data[networkConfigKey(flan)+".json"] = string(b)
+	})
+}
+
+func (s *configMapNetworkStore) DeleteNetworkConfig(flan *v1alpha1.FlannelNetwork) error {
+	return s.update(func(data map[string]string) {
+		delete(data, networkConfigKey(flan)+".json")
+	})
+}
+
+// kubeAnnotationPrefix keeps the node annotations of the flannel clients
+// of different networks apart.
+func kubeAnnotationPrefix(flan *v1alpha1.FlannelNetwork) string {
+	return "vni" + flan.Spec.VNI + "." + v1alpha1.TPRGroup
+}
+
+// useKubernetesDatastore points the flannel client of the network at the
+// Kubernetes API instead of the flannel-server.
+func (c *Operator) useKubernetesDatastore(depl *v1beta1.Deployment, flan *v1alpha1.FlannelNetwork) {
+	podSpec := &depl.Spec.Template.Spec
+	podSpec.Volumes = append(podSpec.Volumes, v1.Volume{
+		Name: "net-conf",
+		VolumeSource: v1.VolumeSource{
+			ConfigMap: &v1.ConfigMapVolumeSource{
+				LocalObjectReference: v1.LocalObjectReference{
+					Name: netConfConfigMapName,
+				},
+			},
+		},
+	})
+
+	container := &podSpec.Containers[0]
+	container.Image = kubeFlannelImage + ":" + c.flannelVersion(flan)
+	// flanneld finds its node through its own pod.
+	container.Env = append(container.Env,
+		v1.EnvVar{
+			Name: "POD_NAME",
+			ValueFrom: &v1.EnvVarSource{
+				FieldRef: &v1.ObjectFieldSelector{
+					FieldPath: "metadata.name",
+				},
+			},
+		},
+		v1.EnvVar{
+			Name: "POD_NAMESPACE",
+			ValueFrom: &v1.EnvVarSource{
+				FieldRef: &v1.ObjectFieldSelector{
+					FieldPath: "metadata.namespace",
+				},
+			},
+		},
+		// The pod runs in the host network, so this is the address the
+		// kubelet probes.
+		v1.EnvVar{
+			Name: "POD_IP",
+			ValueFrom: &v1.EnvVarSource{
+				FieldRef: &v1.ObjectFieldSelector{
+					FieldPath: "status.podIP",
+				},
+			},
+		},
+	)
+	container.VolumeMounts = append(container.VolumeMounts, v1.VolumeMount{
+		Name:      "net-conf",
+		MountPath: netConfDir,
+		ReadOnly:  true,
+	})
+	container.Command = []string{flanneldPath}
+	container.Args = flanneldOptions{
+		KubeSubnetMgr:        true,
+		KubeAnnotationPrefix: kubeAnnotationPrefix(flan),
+		NetConfigPath:        netConfDir + "/" + networkConfigKey(flan) + ".json",
+		SubnetFile:           subnetFilePath(flan),
+		PublicIP:             envRef("NODE_IP"),
+		Iface:                envRef("NODE_IP"),
+		IPMasq:               c.ipMasq(flan),
+		HealthzIP:            envRef("POD_IP"),
+		HealthzPort:          kubeHealthzPort,
+		Verbosity:            1,
+	}.args()
+	container.ReadinessProbe = kubeClientReadinessProbe()
+}
+
+// checkKubernetesDatastore enforces the limits of the Kubernetes datastore:
+// the client needs kubeMinVersion, and as flanneld takes the subnet of a node
+// from its spec.podCIDR, there can only be one network, the oldest one, see
+// createdBefore.
+func (c *Operator) checkKubernetesDatastore(flan *v1alpha1.FlannelNetwork) error {
+	if c.config.Datastore != DatastoreKubernetes {
+		return nil
+	}
+
+	if version := c.flannelVersion(flan); compareVersions(version, kubeMinVersion) < 0 {
+		return fmt.Errorf("the %s datastore requires flannel %s or later, got %s", DatastoreKubernetes, kubeMinVersion, version)
+	}
+
+	for _, other := range c.networks() {
+		if createdBefore(other, flan) {
+			return fmt.Errorf("the %s datastore supports a single FlannelNetwork, %s already exists", DatastoreKubernetes, networkKey(other))
+		}
+	}
+	return nil
+}
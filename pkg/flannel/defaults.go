@@ -0,0 +1,138 @@
+// Copyright 2017 Steffen Gebert
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flannel
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1alpha1"
+)
+
+const (
+	backendVXLAN = "vxlan"
+
+	// defaultPrefixLength is appended to CIDRs given as a bare address.
+	defaultPrefixLength = 16
+
+	// vniLabel makes FlannelNetworks selectable by VNI.
+	vniLabel = v1alpha1.TPRGroup + "/vni"
+)
+
+// backendType returns the flannel backend of the network.
+func backendType(flan *v1alpha1.FlannelNetwork) string {
+	if flan.Spec.Backend != "" {
+		return flan.Spec.Backend
+	}
+	return backendVXLAN
+}
+
+// defaultFlannelNetwork returns a copy of the FlannelNetwork with the
+// defaults filled in: the lowest VNI not used by any of the others, the
+// vxlan backend, the operator's MTU, a /16 prefix for bare addresses and
+// the VNI label. ipMasq and flannelVersion are left empty, so networks keep
+// following the operator's flags.
+func defaultFlannelNetwork(flan *v1alpha1.FlannelNetwork, conf Config, others []*v1alpha1.FlannelNetwork) (*v1alpha1.FlannelNetwork, error) {
+	out := flan.DeepCopy()
+	if out.Labels == nil {
+		out.Labels = map[string]string{}
+	}
+
+	if out.Spec.VNI == "" {
+		vni, err := allocateVNI(others)
+		if err != nil {
+			return nil, err
+		}
+		out.Spec.VNI = vni
+	}
+	if out.Spec.Backend == "" {
+		out.Spec.Backend = backendVXLAN
+	}
+	if out.Spec.MTU == 0 {
+		out.Spec.MTU = conf.MTU
+	}
+	if out.Spec.Cidr != "" && net.ParseIP(out.Spec.Cidr) != nil {
+		out.Spec.Cidr += "/" + strconv.Itoa(defaultPrefixLength)
+	}
+	if _, ok := out.Labels[vniLabel]; !ok {
+		out.Labels[vniLabel] = out.Spec.VNI
+	}
+	return out, nil
+}
+
+// allocateVNI returns the lowest VNI none of the networks uses.
+func allocateVNI(networks []*v1alpha1.FlannelNetwork) (string, error) {
+	used := map[int]bool{}
+	for _, flan := range networks {
+		if vni, err := strconv.Atoi(flan.Spec.VNI); err == nil {
+			used[vni] = true
+		}
+	}
+	for vni := minVNI; vni <= maxVNI; vni++ {
+		if !used[vni] {
+			return strconv.Itoa(vni), nil
+		}
+	}
+	return "", fmt.Errorf("no free VNI left")
+}
+
+// jsonPatchOp is an operation of a JSON patch (RFC 6902).
+type jsonPatchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// defaultsPatch returns the JSON patch turning flan into defaulted, or nil
+// if they don't differ. Only the spec and the labels are defaulted. "add"
+// replaces existing members, so it works whether they are set or not.
+// Labels are added one by one, so labels set by others in the meantime are
+// kept.
+func defaultsPatch(flan, defaulted *v1alpha1.FlannelNetwork) ([]byte, error) {
+	var ops []jsonPatchOp
+	if !reflect.DeepEqual(flan.Spec, defaulted.Spec) {
+		ops = append(ops, jsonPatchOp{Op: "add", Path: "/spec", Value: defaulted.Spec})
+	}
+	if len(flan.Labels) == 0 && len(defaulted.Labels) > 0 {
+		// There is no labels member to add the labels to.
+		ops = append(ops, jsonPatchOp{Op: "add", Path: "/metadata/labels", Value: defaulted.Labels})
+	} else {
+		var keys []string
+		for k, v := range defaulted.Labels {
+			if cur, ok := flan.Labels[k]; !ok || cur != v {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			ops = append(ops, jsonPatchOp{Op: "add", Path: "/metadata/labels/" + escapeJSONPointer(k), Value: defaulted.Labels[k]})
+		}
+	}
+	if len(ops) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(ops)
+}
+
+// escapeJSONPointer escapes a member name for a JSON pointer (RFC 6901),
+// label keys like flannel.st-g.de/vni contain a slash.
+func escapeJSONPointer(s string) string {
+	return strings.Replace(strings.Replace(s, "~", "~0", -1), "/", "~1", -1)
+}
@@ -0,0 +1,230 @@
+// Copyright 2017 Steffen Gebert
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flannel
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1alpha1"
+
+	"k8s.io/client-go/1.5/pkg/api/v1"
+)
+
+func TestDefaultFlannelNetwork(t *testing.T) {
+	conf := Config{MTU: 1400}
+
+	tests := []struct {
+		name       string
+		flan       *v1alpha1.FlannelNetwork
+		others     []*v1alpha1.FlannelNetwork
+		wantSpec   v1alpha1.FlannelNetworkSpec
+		wantLabels map[string]string
+	}{
+		{
+			name: "empty spec",
+			flan: &v1alpha1.FlannelNetwork{},
+			wantSpec: v1alpha1.FlannelNetworkSpec{
+				VNI:     "1",
+				Backend: backendVXLAN,
+				MTU:     1400,
+			},
+			wantLabels: map[string]string{vniLabel: "1"},
+		},
+		{
+			name: "VNI already set",
+			flan: &v1alpha1.FlannelNetwork{
+				Spec: v1alpha1.FlannelNetworkSpec{VNI: "42", Cidr: "10.42.0.0/16"},
+			},
+			others: []*v1alpha1.FlannelNetwork{
+				vniNetwork("tenant-a", "web", "1", "10.1.0.0/16"),
+			},
+			wantSpec: v1alpha1.FlannelNetworkSpec{
+				VNI:     "42",
+				Cidr:    "10.42.0.0/16",
+				Backend: backendVXLAN,
+				MTU:     1400,
+			},
+			wantLabels: map[string]string{vniLabel: "42"},
+		},
+		{
+			name: "VNI gaps across both kinds",
+			flan: &v1alpha1.FlannelNetwork{},
+			others: []*v1alpha1.FlannelNetwork{
+				vniNetwork("tenant-a", "web", "1", "10.1.0.0/16"),
+				// A ClusterFlannelNetwork, which has no namespace.
+				vniNetwork("", "shared", "2", "10.2.0.0/16"),
+				vniNetwork("tenant-b", "web", "4", "10.4.0.0/16"),
+				vniNetwork("tenant-b", "broken", "three", "10.3.0.0/16"),
+			},
+			wantSpec: v1alpha1.FlannelNetworkSpec{
+				VNI:     "3",
+				Backend: backendVXLAN,
+				MTU:     1400,
+			},
+			wantLabels: map[string]string{vniLabel: "3"},
+		},
+		{
+			name: "prefix length and operator MTU",
+			flan: &v1alpha1.FlannelNetwork{
+				Spec: v1alpha1.FlannelNetworkSpec{VNI: "5", Cidr: "10.5.0.0"},
+			},
+			wantSpec: v1alpha1.FlannelNetworkSpec{
+				VNI:     "5",
+				Cidr:    "10.5.0.0/16",
+				Backend: backendVXLAN,
+				MTU:     1400,
+			},
+			wantLabels: map[string]string{vniLabel: "5"},
+		},
+		{
+			name: "backend and MTU already set",
+			flan: &v1alpha1.FlannelNetwork{
+				Spec: v1alpha1.FlannelNetworkSpec{VNI: "5", Backend: "host-gw", MTU: 9000},
+			},
+			wantSpec: v1alpha1.FlannelNetworkSpec{
+				VNI:     "5",
+				Backend: "host-gw",
+				MTU:     9000,
+			},
+			wantLabels: map[string]string{vniLabel: "5"},
+		},
+		{
+			name: "labels merged",
+			flan: &v1alpha1.FlannelNetwork{
+				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{"team": "a"}},
+				Spec:       v1alpha1.FlannelNetworkSpec{VNI: "5"},
+			},
+			wantSpec: v1alpha1.FlannelNetworkSpec{
+				VNI:     "5",
+				Backend: backendVXLAN,
+				MTU:     1400,
+			},
+			wantLabels: map[string]string{"team": "a", vniLabel: "5"},
+		},
+		{
+			name: "VNI label kept",
+			flan: &v1alpha1.FlannelNetwork{
+				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{vniLabel: "custom"}},
+				Spec:       v1alpha1.FlannelNetworkSpec{VNI: "5"},
+			},
+			wantSpec: v1alpha1.FlannelNetworkSpec{
+				VNI:     "5",
+				Backend: backendVXLAN,
+				MTU:     1400,
+			},
+			wantLabels: map[string]string{vniLabel: "custom"},
+		},
+	}
+	for _, tt := range tests {
+		orig := tt.flan.DeepCopy()
+		got, err := defaultFlannelNetwork(tt.flan, conf, tt.others)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got.Spec, tt.wantSpec) {
+			t.Errorf("%s: got spec %+v, want %+v", tt.name, got.Spec, tt.wantSpec)
+		}
+		if !reflect.DeepEqual(got.Labels, tt.wantLabels) {
+			t.Errorf("%s: got labels %v, want %v", tt.name, got.Labels, tt.wantLabels)
+		}
+		if !reflect.DeepEqual(tt.flan, orig) {
+			t.Errorf("%s: the FlannelNetwork was modified", tt.name)
+		}
+	}
+}
+
+func TestDefaultsPatch(t *testing.T) {
+	spec := v1alpha1.FlannelNetworkSpec{VNI: "5", Cidr: "10.5.0.0/16", Backend: backendVXLAN, MTU: 1450}
+
+	tests := []struct {
+		name      string
+		flan      *v1alpha1.FlannelNetwork
+		defaulted *v1alpha1.FlannelNetwork
+		want      string
+	}{
+		{
+			name: "nothing to default",
+			flan: &v1alpha1.FlannelNetwork{
+				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{vniLabel: "5"}},
+				Spec:       spec,
+			},
+			defaulted: &v1alpha1.FlannelNetwork{
+				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{vniLabel: "5"}},
+				Spec:       spec,
+			},
+		},
+		{
+			name: "no labels yet",
+			flan: &v1alpha1.FlannelNetwork{
+				Spec: v1alpha1.FlannelNetworkSpec{VNI: "5", Cidr: "10.5.0.0"},
+			},
+			defaulted: &v1alpha1.FlannelNetwork{
+				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{vniLabel: "5"}},
+				Spec:       spec,
+			},
+			want: `[{"op":"add","path":"/spec","value":{"vni":"5","cidr":"10.5.0.0/16","backend":"vxlan","mtu":1450}},` +
+				`{"op":"add","path":"/metadata/labels","value":{"flannel.st-g.de/vni":"5"}}]`,
+		},
+		{
+			name: "labels added to existing ones",
+			flan: &v1alpha1.FlannelNetwork{
+				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{"team": "a"}},
+				Spec:       spec,
+			},
+			defaulted: &v1alpha1.FlannelNetwork{
+				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{
+					"team":              "a",
+					vniLabel:            "5",
+					"example.com/a~b":   "c",
+					"example.com/other": "d",
+				}},
+				Spec: spec,
+			},
+			want: `[{"op":"add","path":"/metadata/labels/example.com~1a~0b","value":"c"},` +
+				`{"op":"add","path":"/metadata/labels/example.com~1other","value":"d"},` +
+				`{"op":"add","path":"/metadata/labels/flannel.st-g.de~1vni","value":"5"}]`,
+		},
+	}
+	for _, tt := range tests {
+		got, err := defaultsPatch(tt.flan, tt.defaulted)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got patch\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEscapeJSONPointer(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"app", "app"},
+		{"flannel.st-g.de/vni", "flannel.st-g.de~1vni"},
+		{"a~b", "a~0b"},
+		// ~ is escaped first, so ~1 doesn't turn into a slash.
+		{"a~1/b", "a~01~1b"},
+	}
+	for _, tt := range tests {
+		if got := escapeJSONPointer(tt.in); got != tt.want {
+			t.Errorf("escapeJSONPointer(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2017 Steffen Gebert
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flannel
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1alpha1"
+	"github.com/StephenKing/flannel-operator/pkg/diagnostics"
+
+	"k8s.io/client-go/1.5/pkg/api/v1"
+	"k8s.io/client-go/1.5/pkg/fields"
+)
+
+const (
+	diagnosticsName = "flannel-diagnostics"
+	// diagnosticsPort is where the agents serve their reports on the
+	// nodes.
+	diagnosticsPort     = 8891
+	diagnosticsInterval = 1 * time.Minute
+	// Reports older than diagnosticsMaxAge are dropped, e.g. those of
+	// removed nodes.
+	diagnosticsMaxAge = 3 * diagnosticsInterval
+	// maxDiagnosticsProblems limits the problems listed in the status.
+	maxDiagnosticsProblems = 5
+)
+
+// diagnosticsStore keeps the latest report of each node.
+type diagnosticsStore struct {
+	mtx     sync.Mutex
+	reports map[string]diagnostics.NodeReport
+}
+
+func newDiagnosticsStore() *diagnosticsStore {
+	return &diagnosticsStore{reports: map[string]diagnostics.NodeReport{}}
+}
+
+// recent returns the reports younger than diagnosticsMaxAge, ordered by node.
+func (s *diagnosticsStore) recent() []diagnostics.NodeReport {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var nodes []string
+	for node, report := range s.reports {
+		if time.Since(report.Time) > diagnosticsMaxAge {
+			delete(s.reports, node)
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	reports := make([]diagnostics.NodeReport, 0, len(nodes))
+	for _, node := range nodes {
+		reports = append(reports, s.reports[node])
+	}
+	return reports
+}
+
+func (s *diagnosticsStore) put(report diagnostics.NodeReport) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.reports[report.Node] = report
+}
+
+// diagnosticsContainer is the agent sidecar of the flannel-server.
+func (c *Operator) diagnosticsContainer() v1.Container {
+	return v1.Container{
+		Name:    diagnosticsName,
+		Image:   c.config.DiagnosticsImage,
+		Command: []string{"/bin/diagnostics"},
+		Args:    []string{"--listen-address=:" + strconv.Itoa(diagnosticsPort)},
+		Env: []v1.EnvVar{
+			{
+				Name: "NODE_NAME",
+				ValueFrom: &v1.EnvVarSource{
+					FieldRef: &v1.ObjectFieldSelector{
+						FieldPath: "spec.nodeName",
+					},
+				},
+			},
+		},
+		Ports: []v1.ContainerPort{
+			{
+				HostPort:      diagnosticsPort,
+				ContainerPort: diagnosticsPort,
+			},
+		},
+	}
+}
+
+// collectDiagnostics fetches the reports of the agents periodically until
+// stopc is closed.
+func (c *Operator) collectDiagnostics(stopc <-chan struct{}) {
+	ticker := time.NewTicker(diagnosticsInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.fetchDiagnostics(); err != nil {
+			log.Error("Collecting diagnostics failed:", err)
+		}
+
+		select {
+		case <-stopc:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// fetchDiagnostics asks the agent next to every flannel-server pod for its
+// report. Nodes that don't answer keep their last report until it is too
+// old.
+func (c *Operator) fetchDiagnostics() error {
+	pods, err := c.serverPods(fields.Everything())
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	for _, pod := range pods {
+		if pod.Status.HostIP == "" {
+			continue
+		}
+		url := "http://" + pod.Status.HostIP + ":" + strconv.Itoa(diagnosticsPort) + diagnostics.Path
+		resp, err := client.Get(url)
+		if err != nil {
+			log.Warning("Getting diagnostics of node", pod.Spec.NodeName, "failed:", err)
+			continue
+		}
+		var report diagnostics.NodeReport
+		err = json.NewDecoder(resp.Body).Decode(&report)
+		resp.Body.Close()
+		if err != nil {
+			log.Warning("Decoding diagnostics of node", pod.Spec.NodeName, "failed:", err)
+			continue
+		}
+		// The operator's clock decides about the age.
+		report.Time = time.Now()
+		c.diagnostics.put(report)
+	}
+	return nil
+}
+
+// serveDiagnostics answers GET /diagnostics with the reports of all nodes.
+func (c *Operator) serveDiagnostics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.diagnostics.recent())
+}
+
+// diagnoseNetwork summarizes the reports for the network: the number of
+// nodes reporting, the number of nodes the network's device is on, and the
+// problems found with the device.
+func (c *Operator) diagnoseNetwork(flan *v1alpha1.FlannelNetwork) (diagnosed, attached int32, message string) {
+	if c.diagnostics == nil {
+		return 0, 0, ""
+	}
+
+	var problems []string
+	for _, report := range c.diagnostics.recent() {
+		diagnosed++
+		for _, d := range report.Devices {
+			if d.VNI != flan.Spec.VNI {
+				continue
+			}
+			attached++
+			if strings.ToLower(d.OperState) == "down" {
+				problems = append(problems, fmt.Sprintf("%s: %s is down", report.Node, d.Name))
+			}
+			if d.MTU != 0 && d.MTU < c.mtu(flan) {
+				problems = append(problems, fmt.Sprintf("%s: %s has MTU %d, but pods use %d", report.Node, d.Name, d.MTU, c.mtu(flan)))
+			}
+			for _, e := range d.Errors {
+				problems = append(problems, fmt.Sprintf("%s: %s", report.Node, e))
+			}
+		}
+	}
+
+	if len(problems) > maxDiagnosticsProblems {
+		more := len(problems) - maxDiagnosticsProblems
+		problems = append(problems[:maxDiagnosticsProblems], fmt.Sprintf("%d more", more))
+	}
+	return diagnosed, attached, strings.Join(problems, "; ")
+}
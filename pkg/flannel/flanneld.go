@@ -0,0 +1,211 @@
+// Copyright 2017 Steffen Gebert
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flannel
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1alpha1"
+
+	"k8s.io/client-go/1.5/pkg/api/v1"
+	"k8s.io/client-go/1.5/pkg/util/intstr"
+)
+
+const (
+	flanneldPath = "/opt/bin/flanneld"
+
+	// remoteMaxVersion is the first flannel release without the
+	// client/server mode (--listen, --remote and --networks) the etcd
+	// datastore is run in.
+	remoteMaxVersion = "v0.8.0"
+
+	// kubeHealthzPort is where a flannel client of the Kubernetes datastore
+	// serves /healthz on its node. There is a single network with that
+	// datastore, so the clients don't share a node.
+	kubeHealthzPort = 8890
+)
+
+// envRef references an environment variable of the container in its
+// command line. The kubelet expands it, no shell is involved.
+func envRef(name string) string {
+	return "$(" + name + ")"
+}
+
+// flanneldOptions are the flanneld flags the operator sets. Empty values
+// leave a flag out. Each flag becomes a single argument, so values from
+// FlannelNetworks never reach a shell.
+type flanneldOptions struct {
+	// Server mode
+	Listen string
+
+	// Client mode
+	Remote         string
+	RemoteCAFile   string
+	RemoteCertFile string
+	RemoteKeyFile  string
+	Networks       []string
+
+	// etcd
+	EtcdEndpoints []string
+	EtcdPrefix    string
+	EtcdCAFile    string
+	EtcdCertFile  string
+	EtcdKeyFile   string
+
+	// Kubernetes datastore
+	KubeSubnetMgr        bool
+	KubeAnnotationPrefix string
+	NetConfigPath        string
+
+	PublicIP    string
+	Iface       string
+	SubnetFile  string
+	IPMasq      bool
+	HealthzIP   string
+	HealthzPort int
+	Verbosity   int
+}
+
+// args returns the flanneld arguments, in a fixed order so the pod
+// templates and their hashes are stable.
+func (o flanneldOptions) args() []string {
+	var args []string
+	add := func(name, value string) {
+		if value != "" {
+			args = append(args, "--"+name+"="+value)
+		}
+	}
+
+	add("listen", o.Listen)
+	add("remote", o.Remote)
+	add("remote-cafile", o.RemoteCAFile)
+	add("remote-certfile", o.RemoteCertFile)
+	add("remote-keyfile", o.RemoteKeyFile)
+	add("networks", strings.Join(o.Networks, ","))
+	add("etcd-endpoints", strings.Join(o.EtcdEndpoints, ","))
+	add("etcd-prefix", o.EtcdPrefix)
+	add("etcd-cafile", o.EtcdCAFile)
+	add("etcd-certfile", o.EtcdCertFile)
+	add("etcd-keyfile", o.EtcdKeyFile)
+	if o.KubeSubnetMgr {
+		args = append(args, "--kube-subnet-mgr")
+	}
+	add("kube-annotation-prefix", o.KubeAnnotationPrefix)
+	add("net-config-path", o.NetConfigPath)
+	add("public-ip", o.PublicIP)
+	add("iface", o.Iface)
+	add("subnet-file", o.SubnetFile)
+	args = append(args, "--ip-masq="+strconv.FormatBool(o.IPMasq))
+	add("healthz-ip", o.HealthzIP)
+	if o.HealthzPort > 0 {
+		args = append(args, "--healthz-port="+strconv.Itoa(o.HealthzPort))
+	}
+	if o.Verbosity > 0 {
+		args = append(args, "-v="+strconv.Itoa(o.Verbosity))
+	}
+	return args
+}
+
+// compareVersions compares two versions matching flannelVersionRe by their
+// major, minor and patch numbers. A pre-release like v0.10.0-rc1 comes
+// before the release, pre-releases of the same release are compared as
+// strings.
+func compareVersions(a, b string) int {
+	pa, pb := versionNumbers(a), versionNumbers(b)
+	for i := range pa {
+		if pa[i] != pb[i] {
+			if pa[i] < pb[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	ra, rb := preRelease(a), preRelease(b)
+	switch {
+	case ra == rb:
+		return 0
+	case ra == "":
+		return 1
+	case rb == "":
+		return -1
+	case ra < rb:
+		return -1
+	}
+	return 1
+}
+
+func versionNumbers(version string) [3]int {
+	var n [3]int
+	version = strings.SplitN(strings.TrimPrefix(version, "v"), "-", 2)[0]
+	for i, part := range strings.SplitN(version, ".", 3) {
+		n[i], _ = strconv.Atoi(part)
+	}
+	return n
+}
+
+// preRelease returns the pre-release part of the version, e.g. rc1.
+func preRelease(version string) string {
+	if parts := strings.SplitN(version, "-", 2); len(parts) == 2 {
+		return parts[1]
+	}
+	return ""
+}
+
+// clientReadinessProbe checks that the flannel client of the etcd datastore
+// has written the subnet lease of the network, i.e. that pods on the node can
+// be attached to it. Its releases have no /healthz, see remoteMaxVersion.
+func clientReadinessProbe(flan *v1alpha1.FlannelNetwork) *v1.Probe {
+	return &v1.Probe{
+		Handler: v1.Handler{
+			Exec: &v1.ExecAction{
+				Command: []string{"grep", "-q", "^FLANNEL_NETWORK=" + v1alpha1.CanonicalCIDR(flan.Spec.Cidr) + "$", subnetFilePath(flan)},
+			},
+		},
+		InitialDelaySeconds: 5,
+		PeriodSeconds:       10,
+	}
+}
+
+// serverReadinessProbe checks that the flannel-server accepts connections.
+// The releases with /healthz no longer have a server mode, see
+// remoteMaxVersion.
+func serverReadinessProbe() *v1.Probe {
+	return &v1.Probe{
+		Handler: v1.Handler{
+			TCPSocket: &v1.TCPSocketAction{
+				Port: intstr.FromInt(8889),
+			},
+		},
+		InitialDelaySeconds: 5,
+		PeriodSeconds:       10,
+	}
+}
+
+// kubeClientReadinessProbe checks /healthz of the flannel client of the
+// Kubernetes datastore, see kubeMinVersion.
+func kubeClientReadinessProbe() *v1.Probe {
+	return &v1.Probe{
+		Handler: v1.Handler{
+			HTTPGet: &v1.HTTPGetAction{
+				Path: "/healthz",
+				Port: intstr.FromInt(kubeHealthzPort),
+			},
+		},
+		InitialDelaySeconds: 5,
+		PeriodSeconds:       10,
+	}
+}
@@ -0,0 +1,311 @@
+// Copyright 2017 Steffen Gebert
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flannel
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/1.5/pkg/api/v1"
+	"k8s.io/client-go/1.5/pkg/util/intstr"
+)
+
+func TestFlanneldOptionsArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		opts flanneldOptions
+		want []string
+	}{
+		{
+			name: "empty",
+			want: []string{"--ip-masq=false"},
+		},
+		{
+			name: "server",
+			opts: flanneldOptions{
+				Listen:        "$(HOST_PUBLIC_IP):8889",
+				EtcdEndpoints: []string{"https://10.0.0.1:2379", "https://10.0.0.2:2379"},
+				EtcdPrefix:    "/flannel/network",
+				EtcdCAFile:    "/etc/flannel/etcd-tls/ca.crt",
+				EtcdCertFile:  "/etc/flannel/etcd-tls/tls.crt",
+				EtcdKeyFile:   "/etc/flannel/etcd-tls/tls.key",
+				IPMasq:        true,
+			},
+			want: []string{
+				"--listen=$(HOST_PUBLIC_IP):8889",
+				"--etcd-endpoints=https://10.0.0.1:2379,https://10.0.0.2:2379",
+				"--etcd-prefix=/flannel/network",
+				"--etcd-cafile=/etc/flannel/etcd-tls/ca.crt",
+				"--etcd-certfile=/etc/flannel/etcd-tls/tls.crt",
+				"--etcd-keyfile=/etc/flannel/etcd-tls/tls.key",
+				"--ip-masq=true",
+			},
+		},
+		{
+			name: "client",
+			opts: flanneldOptions{
+				Remote:         "$(NODE_IP):8889",
+				RemoteCAFile:   "/etc/flannel/remote-tls/ca.crt",
+				RemoteCertFile: "/etc/flannel/remote-tls/tls.crt",
+				RemoteKeyFile:  "/etc/flannel/remote-tls/tls.key",
+				Networks:       []string{"5"},
+				PublicIP:       "$(NODE_IP)",
+				Iface:          "$(NODE_IP)",
+				Verbosity:      1,
+			},
+			want: []string{
+				"--remote=$(NODE_IP):8889",
+				"--remote-cafile=/etc/flannel/remote-tls/ca.crt",
+				"--remote-certfile=/etc/flannel/remote-tls/tls.crt",
+				"--remote-keyfile=/etc/flannel/remote-tls/tls.key",
+				"--networks=5",
+				"--public-ip=$(NODE_IP)",
+				"--iface=$(NODE_IP)",
+				"--ip-masq=false",
+				"-v=1",
+			},
+		},
+		{
+			name: "several networks",
+			opts: flanneldOptions{Networks: []string{"5", "6"}},
+			want: []string{"--networks=5,6", "--ip-masq=false"},
+		},
+		{
+			name: "kubernetes datastore",
+			opts: flanneldOptions{
+				KubeSubnetMgr:        true,
+				KubeAnnotationPrefix: "vni5.flannel.st-g.de",
+				NetConfigPath:        "/etc/kube-flannel/5.json",
+				SubnetFile:           "/run/flannel/networks/5.env",
+				IPMasq:               true,
+				HealthzIP:            "$(POD_IP)",
+				HealthzPort:          8890,
+			},
+			want: []string{
+				"--kube-subnet-mgr",
+				"--kube-annotation-prefix=vni5.flannel.st-g.de",
+				"--net-config-path=/etc/kube-flannel/5.json",
+				"--subnet-file=/run/flannel/networks/5.env",
+				"--ip-masq=true",
+				"--healthz-ip=$(POD_IP)",
+				"--healthz-port=8890",
+			},
+		},
+		{
+			// Values are passed as single arguments, without a shell
+			// that would split or expand them.
+			name: "no shell",
+			opts: flanneldOptions{EtcdPrefix: "/a b;$(rm -rf /)"},
+			want: []string{"--etcd-prefix=/a b;$(rm -rf /)", "--ip-masq=false"},
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.opts.args(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got\n%q\nwant\n%q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestServerFlanneldOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		conf Config
+		want []string
+	}{
+		{
+			name: "node local etcd",
+			conf: Config{FlannelVersion: "v0.6.2", IPMasq: true},
+			want: []string{
+				"--listen=$(HOST_PUBLIC_IP):8889",
+				"--etcd-endpoints=http://$(HOST_PUBLIC_IP):2379",
+				"--ip-masq=true",
+			},
+		},
+		{
+			name: "etcd with TLS",
+			conf: Config{
+				FlannelVersion: "v0.6.2",
+				EtcdEndpoints:  []string{"https://etcd:2379"},
+				EtcdTLSSecret:  "etcd-tls",
+				EtcdPrefix:     "/flannel",
+			},
+			want: []string{
+				"--listen=$(HOST_PUBLIC_IP):8889",
+				"--etcd-endpoints=https://etcd:2379",
+				"--etcd-prefix=/flannel",
+				"--etcd-cafile=/etc/flannel/etcd-tls/ca.crt",
+				"--etcd-certfile=/etc/flannel/etcd-tls/tls.crt",
+				"--etcd-keyfile=/etc/flannel/etcd-tls/tls.key",
+				"--ip-masq=false",
+			},
+		},
+		{
+			name: "remote TLS",
+			conf: Config{FlannelVersion: "v0.6.2", RemoteTLS: true},
+			want: []string{
+				"--listen=$(HOST_PUBLIC_IP):8889",
+				"--remote-cafile=/etc/flannel/remote-tls/ca.crt",
+				"--remote-certfile=/etc/flannel/remote-tls/tls.crt",
+				"--remote-keyfile=/etc/flannel/remote-tls/tls.key",
+				"--etcd-endpoints=http://$(HOST_PUBLIC_IP):2379",
+				"--ip-masq=false",
+			},
+		},
+	}
+	for _, tt := range tests {
+		c := &Operator{config: tt.conf}
+		if got := c.serverFlanneldOptions().args(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got\n%q\nwant\n%q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestClientFlanneldOptions(t *testing.T) {
+	disabled := false
+
+	tests := []struct {
+		name   string
+		conf   Config
+		ipMasq *bool
+		want   []string
+	}{
+		{
+			name: "defaults",
+			conf: Config{IPMasq: true},
+			want: []string{
+				"--remote=$(NODE_IP):8889",
+				"--networks=5",
+				"--public-ip=$(NODE_IP)",
+				"--iface=$(NODE_IP)",
+				"--ip-masq=true",
+				"-v=1",
+			},
+		},
+		{
+			name:   "ipMasq of the network",
+			conf:   Config{IPMasq: true},
+			ipMasq: &disabled,
+			want: []string{
+				"--remote=$(NODE_IP):8889",
+				"--networks=5",
+				"--public-ip=$(NODE_IP)",
+				"--iface=$(NODE_IP)",
+				"--ip-masq=false",
+				"-v=1",
+			},
+		},
+		{
+			name: "remote TLS",
+			conf: Config{RemoteTLS: true},
+			want: []string{
+				"--remote=$(NODE_IP):8889",
+				"--remote-cafile=/etc/flannel/remote-tls/ca.crt",
+				"--remote-certfile=/etc/flannel/remote-tls/tls.crt",
+				"--remote-keyfile=/etc/flannel/remote-tls/tls.key",
+				"--networks=5",
+				"--public-ip=$(NODE_IP)",
+				"--iface=$(NODE_IP)",
+				"--ip-masq=false",
+				"-v=1",
+			},
+		},
+	}
+	for _, tt := range tests {
+		c := &Operator{config: tt.conf}
+		flan := vniNetwork("tenant-a", "web", "5", "10.5.0.0/16")
+		flan.Spec.IPMasq = tt.ipMasq
+		if got := c.clientFlanneldOptions(flan).args(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got\n%q\nwant\n%q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestClientReadinessProbe(t *testing.T) {
+	tests := []struct {
+		datastore string
+		wantArgs  []string
+		wantProbe v1.Handler
+	}{
+		{
+			datastore: DatastoreEtcd,
+			wantProbe: v1.Handler{
+				Exec: &v1.ExecAction{
+					Command: []string{"grep", "-q", "^FLANNEL_NETWORK=10.5.0.0/16$", "/run/flannel/networks/5.env"},
+				},
+			},
+		},
+		{
+			datastore: DatastoreKubernetes,
+			wantArgs:  []string{"--healthz-ip=$(POD_IP)", "--healthz-port=8890"},
+			wantProbe: v1.Handler{
+				HTTPGet: &v1.HTTPGetAction{
+					Path: "/healthz",
+					Port: intstr.FromInt(8890),
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		c := &Operator{config: Config{Datastore: tt.datastore, FlannelVersion: "v0.10.0"}}
+		depl, err := c.newClientDeployment(vniNetwork("tenant-a", "web", "5", "10.5.0.0/16"))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.datastore, err)
+		}
+		container := depl.Spec.Template.Spec.Containers[0]
+		if got := container.ReadinessProbe.Handler; !reflect.DeepEqual(got, tt.wantProbe) {
+			t.Errorf("%s: got probe %+v, want %+v", tt.datastore, got, tt.wantProbe)
+		}
+		var healthz []string
+		for _, arg := range container.Args {
+			if strings.HasPrefix(arg, "--healthz-") {
+				healthz = append(healthz, arg)
+			}
+		}
+		if !reflect.DeepEqual(healthz, tt.wantArgs) {
+			t.Errorf("%s: got healthz args %q, want %q", tt.datastore, healthz, tt.wantArgs)
+		}
+	}
+}
+
+func TestEnvRef(t *testing.T) {
+	if got, want := envRef("NODE_IP"), "$(NODE_IP)"; got != want {
+		t.Errorf("envRef() = %q, want %q", got, want)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"v0.6.2", "v0.6.2", 0},
+		{"v0.6.2", "v0.7.0", -1},
+		{"v0.10.0", "v0.9.1", 1},
+		{"v1.0.0", "v0.10.0", 1},
+		{"v0.7.1", "v0.7.0", 1},
+		{"v0.10.0-rc1", "v0.10.0", -1},
+		{"v0.10.0", "v0.10.0-rc1", 1},
+		{"v0.10.0-rc1", "v0.10.0-rc2", -1},
+		{"v0.10.0-rc1", "v0.10.0-rc1", 0},
+		{"v0.10.0-rc1", "v0.9.1", 1},
+		{"v0.9.1-amd64", "v0.10.0", -1},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
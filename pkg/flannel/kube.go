@@ -0,0 +1,325 @@
+// Copyright 2017 Steffen Gebert
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flannel
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1alpha1"
+
+	"k8s.io/client-go/1.5/pkg/api"
+	"k8s.io/client-go/1.5/pkg/api/errors"
+	"k8s.io/client-go/1.5/pkg/api/v1"
+	"k8s.io/client-go/1.5/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/1.5/pkg/version"
+	"k8s.io/client-go/1.5/rest"
+)
+
+const (
+	// minKubeMinor is the first Kubernetes 1.x release with CRDs serving
+	// several versions.
+	minKubeMinor = 11
+	// maxKubeMinor is the first Kubernetes 1.x release without the
+	// extensions/v1beta1 DaemonSets and Deployments.
+	maxKubeMinor = 16
+)
+
+// specHashAnnotation holds the hash of the spec the operator last wrote to
+// a DaemonSet or Deployment. The specs can't be compared directly, as the
+// apiserver fills in defaults.
+const specHashAnnotation = v1alpha1.TPRGroup + "/spec-hash"
+
+// objectHash identifies the JSON representation of obj.
+func objectHash(obj interface{}) string {
+	b, _ := json.Marshal(obj)
+	h := fnv.New32a()
+	h.Write(b)
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// setSpecHash records the hash of spec in the annotations of meta.
+func setSpecHash(meta *v1.ObjectMeta, spec interface{}) string {
+	hash := objectHash(spec)
+	if meta.Annotations == nil {
+		meta.Annotations = map[string]string{}
+	}
+	meta.Annotations[specHashAnnotation] = hash
+	return hash
+}
+
+// createOrUpdateConfigMap creates the ConfigMap or replaces the data of an
+// existing one with the same name, unless the data is the same already.
+func (c *Operator) createOrUpdateConfigMap(cm *v1.ConfigMap) error {
+	cmClient := c.kclient.Core().ConfigMaps(cm.Namespace)
+
+	existing, err := cmClient.Get(cm.Name)
+	if errors.IsNotFound(err) {
+		if c.dryRun(planCreate, "ConfigMap", cm.Namespace, cm.Name, nil, cm.Data) {
+			return nil
+		}
+		_, err = cmClient.Create(cm)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if dataEqual(existing.Data, cm.Data) {
+		return nil
+	}
+	if c.dryRun(planUpdate, "ConfigMap", cm.Namespace, cm.Name, existing.Data, cm.Data) {
+		return nil
+	}
+
+	cm.ResourceVersion = existing.ResourceVersion
+	_, err = cmClient.Update(cm)
+	return err
+}
+
+// createConfigMapIfNotExists creates the ConfigMap unless there already is
+// one with the same name.
+func (c *Operator) createConfigMapIfNotExists(cm *v1.ConfigMap) error {
+	cmClient := c.kclient.Core().ConfigMaps(cm.Namespace)
+
+	_, err := cmClient.Get(cm.Name)
+	if !errors.IsNotFound(err) {
+		return err
+	}
+	if c.dryRun(planCreate, "ConfigMap", cm.Namespace, cm.Name, nil, cm.Data) {
+		return nil
+	}
+
+	_, err = cmClient.Create(cm)
+	if errors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
+
+// createOrUpdateDaemonSet creates the DaemonSet or replaces the spec of an
+// existing one with the same name, unless the spec is the same already.
+func (c *Operator) createOrUpdateDaemonSet(ds *v1beta1.DaemonSet) error {
+	dsetClient := c.kclient.Extensions().DaemonSets(ds.Namespace)
+	hash := setSpecHash(&ds.ObjectMeta, ds.Spec)
+
+	existing, err := dsetClient.Get(ds.Name)
+	if errors.IsNotFound(err) {
+		if c.dryRun(planCreate, "DaemonSet", ds.Namespace, ds.Name, nil, ds.Spec) {
+			return nil
+		}
+		_, err = dsetClient.Create(ds)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if existing.Annotations[specHashAnnotation] == hash {
+		return nil
+	}
+	if c.dryRun(planUpdate, "DaemonSet", ds.Namespace, ds.Name, existing.Spec, ds.Spec) {
+		return nil
+	}
+
+	ds.ResourceVersion = existing.ResourceVersion
+	if ds.Spec.Selector == nil {
+		// The selector got defaulted on creation and must not change.
+		ds.Spec.Selector = existing.Spec.Selector
+	}
+	_, err = dsetClient.Update(ds)
+	return err
+}
+
+// createOrUpdateSecret creates the Secret or replaces the data of an
+// existing one with the same name.
+func (c *Operator) createOrUpdateSecret(secret *v1.Secret) error {
+	secretClient := c.kclient.Core().Secrets(secret.Namespace)
+
+	existing, err := secretClient.Get(secret.Name)
+	if errors.IsNotFound(err) {
+		if c.dryRun(planCreate, "Secret", secret.Namespace, secret.Name, nil, redactedSecretData(secret)) {
+			return nil
+		}
+		_, err = secretClient.Create(secret)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if c.dryRun(planUpdate, "Secret", secret.Namespace, secret.Name, redactedSecretData(existing), redactedSecretData(secret)) {
+		return nil
+	}
+
+	secret.ResourceVersion = existing.ResourceVersion
+	_, err = secretClient.Update(secret)
+	return err
+}
+
+// createOrUpdateDeployment creates the Deployment or replaces the spec of an
+// existing one with the same name, unless the spec is the same already.
+func (c *Operator) createOrUpdateDeployment(depl *v1beta1.Deployment) error {
+	deplClient := c.kclient.Extensions().Deployments(depl.Namespace)
+	hash := setSpecHash(&depl.ObjectMeta, depl.Spec)
+
+	existing, err := deplClient.Get(depl.Name)
+	if errors.IsNotFound(err) {
+		if c.dryRun(planCreate, "Deployment", depl.Namespace, depl.Name, nil, depl.Spec) {
+			return nil
+		}
+		_, err = deplClient.Create(depl)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if existing.Annotations[specHashAnnotation] == hash {
+		return nil
+	}
+	if c.dryRun(planUpdate, "Deployment", depl.Namespace, depl.Name, existing.Spec, depl.Spec) {
+		return nil
+	}
+
+	depl.ResourceVersion = existing.ResourceVersion
+	if depl.Spec.Selector == nil {
+		// The selector got defaulted on creation and must not change.
+		depl.Spec.Selector = existing.Spec.Selector
+	}
+	_, err = deplClient.Update(depl)
+	return err
+}
+
+// dataEqual compares the data of two ConfigMaps, treating nil as empty.
+func dataEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// checkServerVersion returns the minor version of the Kubernetes 1.x
+// apiserver, or an error if the operator doesn't support it: the CRDs serve
+// several versions, which takes minKubeMinor, and the workloads are created
+// through extensions/v1beta1, which is gone in maxKubeMinor.
+func checkServerVersion(info *version.Info) (int, error) {
+	// Some distributions append a + to the minor version, e.g. 14+.
+	minor, err := strconv.Atoi(strings.TrimSuffix(info.Minor, "+"))
+	if info.Major != "1" || err != nil {
+		return 0, fmt.Errorf("unknown Kubernetes version %s.%s", info.Major, info.Minor)
+	}
+	if minor < minKubeMinor || minor >= maxKubeMinor {
+		return 0, fmt.Errorf("Kubernetes 1.%d is not supported, it has to be at least 1.%d and before 1.%d", minor, minKubeMinor, maxKubeMinor)
+	}
+	return minor, nil
+}
+
+// restClient returns the REST client for kinds the typed clients don't know.
+// The fake clientsets of client-go have none, so the operator can't
+// register its CRDs and webhooks through them.
+func (c *Operator) restClient() (*rest.RESTClient, error) {
+	rc := c.kclient.Core().GetRESTClient()
+	if rc == nil {
+		return nil, fmt.Errorf("the clientset has no REST client")
+	}
+	return rc, nil
+}
+
+// createOrPatchRaw creates obj in the collection at path, for kinds the
+// typed clients don't know, or applies the JSON merge patch to an existing
+// object with the same name.
+func (c *Operator) createOrPatchRaw(kind, path, name string, obj, patch interface{}) error {
+	rc, err := c.restClient()
+	if err != nil {
+		return fmt.Errorf("create %s %s: %s", kind, name, err)
+	}
+
+	if c.plan != nil {
+		err := rc.Get().AbsPath(path, name).Do().Error()
+		if errors.IsNotFound(err) {
+			c.dryRun(planCreate, kind, "", name, nil, obj)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		c.dryRun(planUpdate, kind, "", name, nil, patch)
+		return nil
+	}
+
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	err = rc.Post().AbsPath(path).SetHeader("Content-Type", "application/json").Body(body).Do().Error()
+	if err == nil {
+		log.Notice(kind, "created:", name)
+		return nil
+	}
+	if !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("create %s %s: %s", kind, name, err)
+	}
+
+	body, err = json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	if err := rc.Patch(api.MergePatchType).AbsPath(path, name).Body(body).Do().Error(); err != nil {
+		return fmt.Errorf("patch %s %s: %s", kind, name, err)
+	}
+	return nil
+}
+
+// patchRaw applies the JSON merge patch to the object at path, for fields
+// the typed clients don't know.
+func (c *Operator) patchRaw(kind, path, namespace, name string, patch interface{}) error {
+	rc, err := c.restClient()
+	if err != nil {
+		return fmt.Errorf("patch %s %s: %s", kind, name, err)
+	}
+	if c.dryRun(planUpdate, kind, namespace, name, nil, patch) {
+		return nil
+	}
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	if err := rc.Patch(api.MergePatchType).AbsPath(path, name).Body(body).Do().Error(); err != nil {
+		return fmt.Errorf("patch %s %s: %s", kind, name, err)
+	}
+	return nil
+}
+
+// deleteRaw deletes the object of a kind the typed clients don't know.
+// Objects that are already gone are fine.
+func (c *Operator) deleteRaw(kind, path, name string) error {
+	rc, err := c.restClient()
+	if err != nil {
+		return fmt.Errorf("delete %s %s: %s", kind, name, err)
+	}
+	if c.dryRun(planDelete, kind, "", name, nil, nil) {
+		return nil
+	}
+	err = rc.Delete().AbsPath(path, name).Do().Error()
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("delete %s %s: %s", kind, name, err)
+	}
+	return nil
+}
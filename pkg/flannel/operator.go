@@ -16,20 +16,25 @@ package flannel
 
 import (
 	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/op/go-logging"
 
 	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1alpha1"
+	flannelv1beta1 "github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1beta1"
 
 	"k8s.io/client-go/1.5/kubernetes"
 	"k8s.io/client-go/1.5/pkg/api"
 	"k8s.io/client-go/1.5/pkg/api/errors"
-	"k8s.io/client-go/1.5/pkg/api/resource"
 	"k8s.io/client-go/1.5/pkg/api/unversioned"
 	"k8s.io/client-go/1.5/pkg/api/v1"
 	"k8s.io/client-go/1.5/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/1.5/pkg/runtime"
 	"k8s.io/client-go/1.5/pkg/util/intstr"
+	"k8s.io/client-go/1.5/pkg/watch"
 	"k8s.io/client-go/1.5/rest"
 	"k8s.io/client-go/1.5/tools/cache"
 )
@@ -42,21 +47,115 @@ const (
 	resyncPeriod = 1 * time.Minute
 	kubeSystemNamespace = "kube-system"
 	dsetFlannelName = "flannel-server"
-	dsetFlannelVersion = "v0.6.2"
-	tprFlannelNetwork = "flannel-network." + v1alpha1.TPRGroup
 )
 
+const (
+	flannelImage = "giantswarm/flannel"
+	etcdTLSDir   = "/etc/flannel/etcd-tls"
+
+	// drainTimeout is how long Stop waits for running reconciles.
+	drainTimeout = 30 * time.Second
+)
+
+// Config holds the operator's defaults for FlannelNetworks that don't
+// override them in their spec.
+type Config struct {
+	// IPMasq enables IP masquerading for traffic leaving the networks.
+	IPMasq bool
+	// MTU of the pod interfaces. Zero means defaultMTU.
+	MTU int
+
+	// Datastore is either DatastoreEtcd (the default) or
+	// DatastoreKubernetes.
+	Datastore string
+
+	// EtcdEndpoints the flannel-server connects to. Defaults to a
+	// plaintext etcd on port 2379 of each node.
+	EtcdEndpoints []string
+	// EtcdTLSSecret names a Secret in kube-system holding ca.crt, tls.crt
+	// and tls.key for the connection to etcd.
+	EtcdTLSSecret string
+	// EtcdPrefix is the etcd key prefix of the flannel network configs.
+	EtcdPrefix string
+
+	// FlannelVersion is the flannel release run by the flannel-server
+	// and, unless overridden in their spec, the flannel clients.
+	FlannelVersion string
+
+	// RemoteTLS secures the connections between the flannel clients and
+	// the flannel-server with certificates issued by the operator.
+	RemoteTLS bool
+
+	// ServerResources are the resources of the flannel-server container.
+	ServerResources v1alpha1.ResourceRequirements
+	// ClientResources are the resources of the flannel client containers
+	// of networks that don't set spec.resources.
+	ClientResources v1alpha1.ResourceRequirements
+
+	// PodTemplateOverrides are merged onto the pod templates of all pods
+	// the operator runs.
+	PodTemplateOverrides *v1alpha1.PodTemplateOverrides
+
+	// CNIPluginImage is the image the flannel-select CNI plugin is
+	// installed on the nodes from, usually the operator's own.
+	CNIPluginImage string
+
+	// DiagnosticsImage is the image with the diagnostics agent run next
+	// to the flannel-server, usually the operator's own. Empty disables
+	// the diagnostics.
+	DiagnosticsImage string
+
+	// WebhookListenAddress is where the admission webhooks are served with
+	// TLS. Empty disables them.
+	WebhookListenAddress string
+	// WebhookService and WebhookNamespace name the Service the apiserver
+	// reaches the webhooks through. They go into the serving certificate.
+	WebhookService   string
+	WebhookNamespace string
+
+	// DryRun has the operator only record the writes it would do, see
+	// the /plan endpoint.
+	DryRun bool
+}
+
 // Operator manages the life cycle of the flannel deployments
 type Operator struct {
-	kclient *kubernetes.Clientset
-	fclient *v1alpha1.FlannelNetworkV1alpha1Client
+	kclient kubernetes.Interface
+	fclient v1alpha1.NetworksGetter
+	config  Config
+
+	netStore networkStore
+	// plan records the writes in dry-run mode, nil otherwise.
+	plan *planRecorder
+	// diagnostics keeps the reports of the diagnostics agents, nil if
+	// they are disabled.
+	diagnostics *diagnosticsStore
+
+	// certMtx serializes certificate renewals.
+	certMtx sync.Mutex
+	// rolloutc has rolloutServer run before the next resync, see
+	// triggerRollout.
+	rolloutc chan struct{}
+	// webhookCert holds the *tls.Certificate the webhooks are served with.
+	webhookCert atomic.Value
+	// kubeMinor is the minor version of the apiserver, set by Run.
+	kubeMinor int
+
+	// inflight tracks running reconciles, so Stop can wait for them.
+	// stopping is set by Stop to turn away new ones.
+	stopMtx  sync.Mutex
+	stopping bool
+	inflight sync.WaitGroup
 
-	flanInf cache.SharedIndexInformer
-	nodeInf cache.SharedIndexInformer
+	flanInf    cache.SharedIndexInformer
+	flanLister v1alpha1.FlannelNetworkLister
+	clusterInf cache.SharedIndexInformer
+	nodeInf    cache.SharedIndexInformer
+	nsInf      cache.SharedIndexInformer
 }
 
 // New creates a new controller
-func New(cfg *rest.Config) (*Operator, error) {
+func New(cfg *rest.Config, conf Config) (*Operator, error) {
 	log.Notice("About to create new flannel operator")
 
 	kclient, err := kubernetes.NewForConfig(cfg)
@@ -71,29 +170,110 @@ func New(cfg *rest.Config) (*Operator, error) {
 		return nil, err
 	}
 
+	return NewWithClients(kclient, fclient, conf)
+}
+
+// NewWithClients creates a new controller on top of the given clients, e.g.
+// the fakes of k8s.io/client-go/1.5/kubernetes/fake and
+// pkg/client/flannelnetwork/v1alpha1/fake.
+func NewWithClients(kclient kubernetes.Interface, fclient v1alpha1.NetworksGetter, conf Config) (*Operator, error) {
+	if conf.MTU == 0 {
+		conf.MTU = defaultMTU
+	}
+	if conf.Datastore == "" {
+		conf.Datastore = DatastoreEtcd
+	}
+	if conf.FlannelVersion == "" {
+		conf.FlannelVersion = defaultFlannelVersion
+	}
+	if conf.CNIPluginImage == "" {
+		conf.CNIPluginImage = defaultCNIPluginImage
+	}
+	if conf.WebhookService == "" {
+		conf.WebhookService = "flannel-operator"
+	}
+	if conf.WebhookNamespace == "" {
+		conf.WebhookNamespace = kubeSystemNamespace
+	}
+	if err := validateConfig(conf); err != nil {
+		return nil, err
+	}
+
 	o := &Operator{
-		kclient: kclient,
-		fclient: fclient,
+		kclient:  kclient,
+		fclient:  fclient,
+		config:   conf,
+		rolloutc: make(chan struct{}, 1),
+	}
+	if conf.DryRun {
+		log.Warning("Running in dry-run mode, no changes will be made")
+		o.plan = newPlanRecorder()
+	}
+	if conf.DiagnosticsImage != "" {
+		o.diagnostics = newDiagnosticsStore()
+	}
+
+	var err error
+	o.netStore, err = o.newNetworkStore()
+	if err != nil {
+		return nil, err
 	}
 
 	// Watch for new FlannelNetwork creations to make sure that we
 	// have a FlannelClient running.
-	o.flanInf = cache.NewSharedIndexInformer(
-		&cache.ListWatch{
-			ListFunc: o.fclient.FlannelNetworks(api.NamespaceAll).List,
-			WatchFunc: o.fclient.FlannelNetworks(api.NamespaceAll).Watch,
-		},
-		&v1alpha1.FlannelNetwork{}, resyncPeriod, cache.Indexers{},
-	)
+	flanInf := v1alpha1.NewFlannelNetworkInformer(o.fclient, api.NamespaceAll, resyncPeriod)
+	o.flanInf = flanInf.Informer()
+	o.flanLister = flanInf.Lister()
 	o.flanInf.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc:    o.handleAddFlannelNetwork,
 		DeleteFunc: o.handleDeleteFlannelNetwork,
-		//UpdateFunc: o.handleUpdateFlannelNetwork,
+		UpdateFunc: o.handleUpdateFlannelNetwork,
+	})
+	o.clusterInf = v1alpha1.NewClusterFlannelNetworkInformer(o.fclient, resyncPeriod)
+	o.clusterInf.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    o.handleAddClusterFlannelNetwork,
+		DeleteFunc: o.handleDeleteClusterFlannelNetwork,
+		UpdateFunc: o.handleUpdateClusterFlannelNetwork,
 	})
 
 	log.Notice("Added Event handlers")
 
-	o.createDaemonSet()
+	// Namespaces are only watched to resolve their FlannelNetwork binding.
+	o.nsInf = cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options api.ListOptions) (runtime.Object, error) {
+				return o.kclient.Core().Namespaces().List(options)
+			},
+			WatchFunc: func(options api.ListOptions) (watch.Interface, error) {
+				return o.kclient.Core().Namespaces().Watch(options)
+			},
+		},
+		&v1.Namespace{}, resyncPeriod, cache.Indexers{},
+	)
+	o.nsInf.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    o.handleAddNamespace,
+		DeleteFunc: o.handleDeleteNamespace,
+		UpdateFunc: o.handleUpdateNamespace,
+	})
+
+	if conf.RemoteTLS {
+		// Nodes are watched to keep their names in the flannel-server
+		// certificate.
+		o.nodeInf = cache.NewSharedIndexInformer(
+			&cache.ListWatch{
+				ListFunc: func(options api.ListOptions) (runtime.Object, error) {
+					return o.kclient.Core().Nodes().List(options)
+				},
+				WatchFunc: func(options api.ListOptions) (watch.Interface, error) {
+					return o.kclient.Core().Nodes().Watch(options)
+				},
+			},
+			&v1.Node{}, resyncPeriod, cache.Indexers{},
+		)
+		o.nodeInf.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: o.handleAddNode,
+		})
+	}
 
 	log.Notice("Done with Operator.New")
 
@@ -102,24 +282,164 @@ func New(cfg *rest.Config) (*Operator, error) {
 
 func (c *Operator) Run(stopc <-chan struct{}) error {
 	log.Notice("Called Operator.Run")
+
+	info, err := c.kclient.Discovery().ServerVersion()
+	if err != nil {
+		return fmt.Errorf("get server version: %s", err)
+	}
+	if c.kubeMinor, err = checkServerVersion(info); err != nil {
+		return err
+	}
+
 	go c.flanInf.Run(stopc)
+	go c.clusterInf.Run(stopc)
+	go c.nsInf.Run(stopc)
+	if c.config.RemoteTLS {
+		go c.nodeInf.Run(stopc)
+		go c.renewCertificates(stopc)
+	}
+	if c.config.WebhookListenAddress != "" {
+		go c.runWebhook(stopc)
+	}
+	if c.diagnostics != nil {
+		go c.collectDiagnostics(stopc)
+	}
 
-	defer c.Stop()
+	c.createDaemonSet()
 
-	if err := c.createTPRs(); err != nil {
-		log.Warning("Create TPRs failed:", err)
+	if err := c.createCNIInstallerDaemonSet(); err != nil {
+		log.Error("Creating CNI installer failed:", err)
 	}
 
+	// The conversion webhook is only registered along with a CA, see
+	// issueWebhookCertificate for the first start.
+	if caBundle, err := c.caBundle(); err != nil {
+		log.Warning("Create CRDs failed:", err)
+	} else if err := c.createCRDs(caBundle); err != nil {
+		log.Warning("Create CRDs failed:", err)
+	}
+
+	go func() {
+		for !c.cachesSynced() {
+			select {
+			case <-stopc:
+				return
+			case <-time.After(time.Second):
+			}
+		}
+		if !c.beginReconcile() {
+			return
+		}
+		defer c.inflight.Done()
+
+		c.syncNetworkConfigs()
+	}()
+
+	go c.rolloutServerPeriodically(stopc)
+
 	<-stopc
 	log.Notice("Operator.Run received stop signal")
-	return nil
+	return c.Stop()
+}
+
+// RegisterHandlers adds the HTTP endpoints of the operator to mux.
+func (c *Operator) RegisterHandlers(mux *http.ServeMux) {
+	if c.plan != nil {
+		mux.Handle("/plan", c.plan)
+	}
+	if c.diagnostics != nil {
+		mux.HandleFunc("/diagnostics", c.serveDiagnostics)
+	}
+}
+
+// renewCertificates issues the TLS certificates once the nodes are known
+// and renews them periodically until stopc is closed.
+func (c *Operator) renewCertificates(stopc <-chan struct{}) {
+	ticker := time.NewTicker(certCheckInterval)
+	defer ticker.Stop()
+
+	for !c.nodeInf.HasSynced() {
+		select {
+		case <-stopc:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+
+	for {
+		c.syncCertificatesLocked()
+
+		select {
+		case <-stopc:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Operator) syncCertificatesLocked() {
+	if !c.beginReconcile() {
+		return
+	}
+	defer c.inflight.Done()
+
+	c.certMtx.Lock()
+	defer c.certMtx.Unlock()
+
+	if err := c.syncCertificates(); err != nil {
+		log.Error("Syncing TLS certificates failed:", err)
+	}
 }
 
+func (c *Operator) handleAddNode(obj interface{}) {
+	// The initial sync is left to renewCertificates.
+	if !c.nodeInf.HasSynced() {
+		return
+	}
+
+	log.Notice("Node added:", obj.(*v1.Node).Name)
+	c.syncCertificatesLocked()
+}
+
+// beginReconcile registers a reconcile with the operator, which must call
+// c.inflight.Done() when finished. It returns false once the operator is
+// stopping, in which case the reconcile must be skipped.
+func (c *Operator) beginReconcile() bool {
+	c.stopMtx.Lock()
+	defer c.stopMtx.Unlock()
+
+	if c.stopping {
+		return false
+	}
+	c.inflight.Add(1)
+	return true
+}
+
+// Stop waits up to drainTimeout for running reconciles to finish, so no
+// object is left half updated. Everything the operator created stays in
+// place, see Uninstall for removing it.
 func (c *Operator) Stop() error {
 	log.Notice("Shutting down operator")
 
+	c.stopMtx.Lock()
+	c.stopping = true
+	c.stopMtx.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		c.inflight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		log.Notice("All reconciles finished")
+	case <-time.After(drainTimeout):
+		return fmt.Errorf("reconciles still running after %s", drainTimeout)
+	}
+
 	log.Notice("Leaving all FlannelNetworks in place")
-	log.Notice("Leaving FlannelNetwork TPR in place")
+	log.Notice("Leaving all ClusterFlannelNetworks in place")
+	log.Notice("Leaving FlannelNetwork CRDs in place")
 	log.Notice("Leaving flannel-client deployments in place")
 	log.Notice("Leaving flannel-server DaemonSets in place")
 
@@ -129,7 +449,43 @@ func (c *Operator) Stop() error {
 func (c *Operator) createDaemonSet() error {
 	log.Notice("Creating DaemonSet for flannel-server")
 
-	dsetClient := c.kclient.ExtensionsClient.DaemonSets(kubeSystemNamespace)
+	if err := c.createPolicyConfigMap(); err != nil {
+		return err
+	}
+
+	dsetClient := c.kclient.Extensions().DaemonSets(kubeSystemNamespace)
+
+	existing, err := dsetClient.Get(dsetFlannelName)
+	if err == nil {
+		// Changes to an existing DaemonSet are rolled out node by node
+		// by rolloutServer.
+		log.Notice("DaemonSet exists with version", existing.Labels["version"])
+		return nil
+	}
+	if !errors.IsNotFound(err) {
+		return fmt.Errorf("get daemonset: %s", err)
+	}
+
+	ds, err := c.newServerDaemonSet()
+	if err != nil {
+		return err
+	}
+	if c.dryRun(planCreate, "DaemonSet", ds.Namespace, ds.Name, nil, ds.Spec) {
+		return nil
+	}
+	if _, err := dsetClient.Create(ds); err != nil {
+		return fmt.Errorf("create daemonset: %s", err)
+	}
+
+	log.Notice("DaemonSet created")
+	return nil
+}
+
+// newServerDaemonSet returns the desired flannel-server DaemonSet. Its pod
+// template is labelled with a hash of itself, so pods of older templates
+// can be told apart during a rollout.
+func (c *Operator) newServerDaemonSet() (*v1beta1.DaemonSet, error) {
+	version := c.config.FlannelVersion
 
 	// this is based on Timo's gist
 	// https://gist.github.com/teemow/89dec8b5124123714f4036a76d7e74aa
@@ -143,17 +499,17 @@ func (c *Operator) createDaemonSet() error {
 			Name:      dsetFlannelName,
 			Labels: map[string]string{
 				"app":     dsetFlannelName,
-				"version": dsetFlannelVersion,
+				"version": version,
 			},
 		},
 		Spec: v1beta1.DaemonSetSpec{
-			// Selector? We don't need the that here, I guess.
-			//Selector: &unversioned.LabelSelector{
-			//	MatchLabels: map[string]string{
-			//		"app":		name,
-			//		"version":	version,
-			//	},
-			//},
+			// The version is left out, so the selector still matches
+			// after an upgrade.
+			Selector: &v1beta1.LabelSelector{
+				MatchLabels: map[string]string{
+					"app": dsetFlannelName,
+				},
+			},
 			Template: v1.PodTemplateSpec{
 				// Do we need those? Won't harm, I guess..
 				ObjectMeta: v1.ObjectMeta{
@@ -163,7 +519,7 @@ func (c *Operator) createDaemonSet() error {
 					},
 					Labels: map[string]string{
 						"app":     dsetFlannelName,
-						"version": dsetFlannelVersion,
+						"version": version,
 					},
 				},
 				Spec: v1.PodSpec{
@@ -172,7 +528,7 @@ func (c *Operator) createDaemonSet() error {
 						{
 							// Flannel running in server mode listens for connections on 8889
 							Name:  "flannel-server",
-							Image: "giantswarm/flannel:" + dsetFlannelVersion,
+							Image: flannelImage + ":" + version,
 							Env: []v1.EnvVar{
 								{
 									Name: "HOST_PUBLIC_IP",
@@ -183,24 +539,15 @@ func (c *Operator) createDaemonSet() error {
 									},
 								},
 							},
-							Command: []string{
-								"/bin/sh",
-								"-c",
-								"/opt/bin/flanneld -listen ${HOST_PUBLIC_IP}:8889 -etcd-endpoints http://${HOST_PUBLIC_IP}:2379 -ip-masq=true",
-							},
+							Command: []string{flanneldPath},
+							Args:    c.serverFlanneldOptions().args(),
 							Ports: []v1.ContainerPort{
 								{
 									HostPort:      8889,
 									ContainerPort: 8889,
 								},
 							},
-							Resources: v1.ResourceRequirements{
-								Limits: v1.ResourceList{
-									"cpu": resource.Quantity{
-										Format: "200m",
-									},
-								},
-							},
+							Resources: resourceRequirements(c.config.ServerResources),
 							VolumeMounts: []v1.VolumeMount{
 								{
 									Name:      "varlogflannel",
@@ -222,6 +569,25 @@ func (c *Operator) createDaemonSet() error {
 								InitialDelaySeconds: 30,
 								TimeoutSeconds:      5,
 							},
+							ReadinessProbe: serverReadinessProbe(),
+						},
+						{
+							// Applies the isolation rules between the
+							// FlannelNetworks on the node.
+							Name:    "flannel-policy",
+							Image:   flannelImage + ":" + version,
+							Command: []string{"/bin/sh", "-c", policyApplyScript},
+							SecurityContext: &v1.SecurityContext{
+								Capabilities: &v1.Capabilities{
+									Add: []v1.Capability{"NET_ADMIN"},
+								},
+							},
+							VolumeMounts: []v1.VolumeMount{
+								{
+									Name:      "policy-rules",
+									MountPath: "/etc/flannel-policy",
+								},
+							},
 						},
 					},
 					Volumes: []v1.Volume{
@@ -239,6 +605,15 @@ func (c *Operator) createDaemonSet() error {
 									Path: "/var/run/flannel",
 								},
 							},
+						}, {
+							Name: "policy-rules",
+							VolumeSource: v1.VolumeSource{
+								ConfigMap: &v1.ConfigMapVolumeSource{
+									LocalObjectReference: v1.LocalObjectReference{
+										Name: policyConfigMapName,
+									},
+								},
+							},
 						},
 					},
 				},
@@ -246,73 +621,149 @@ func (c *Operator) createDaemonSet() error {
 		},
 	}
 
-	if _, err := dsetClient.Create(daemonSet); err != nil {
-		return fmt.Errorf("create daemonset: %s", err)
+	podSpec := &daemonSet.Spec.Template.Spec
+	if c.config.RemoteTLS {
+		volume, mount := remoteTLSVolume(serverSecretName)
+		podSpec.Volumes = append(podSpec.Volumes, volume)
+		podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, mount)
+		hash, err := c.tlsHash(serverSecretName)
+		if err != nil {
+			return nil, err
+		}
+		daemonSet.Spec.Template.Annotations[tlsHashAnnotation] = hash
+	}
+	if c.config.EtcdTLSSecret != "" {
+		podSpec.Volumes = append(podSpec.Volumes, v1.Volume{
+			Name: "etcd-tls",
+			VolumeSource: v1.VolumeSource{
+				Secret: &v1.SecretVolumeSource{
+					SecretName: c.config.EtcdTLSSecret,
+				},
+			},
+		})
+		podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, v1.VolumeMount{
+			Name:      "etcd-tls",
+			MountPath: etcdTLSDir,
+			ReadOnly:  true,
+		})
+	}
+	if c.config.Datastore == DatastoreKubernetes {
+		// Without etcd there is no flannel-server, but the isolation
+		// rules still have to be applied on every node.
+		podSpec.Containers = podSpec.Containers[1:]
+	}
+	if c.config.DiagnosticsImage != "" {
+		podSpec.Containers = append(podSpec.Containers, c.diagnosticsContainer())
 	}
 
-	log.Notice("DaemonSet created")
-	return nil
-}
-
-func (c *Operator) deleteDaemonSet() error {
-	log.Notice("Deleting DaemonSet", dsetFlannelName)
-
-	dsetClient := c.kclient.ExtensionsClient.DaemonSets(kubeSystemNamespace)
-	// remove all the pods, not only the DaemonSet
-	var orphan bool = false
-	deleteOptions := &api.DeleteOptions{
-		OrphanDependents: &orphan,
+	if err := applyPodTemplateOverrides(&daemonSet.Spec.Template, c.config.PodTemplateOverrides); err != nil {
+		return nil, err
 	}
 
-	return dsetClient.Delete(dsetFlannelName, deleteOptions)
+	daemonSet.Spec.Template.Labels[templateHashLabel] = templateHash(daemonSet.Spec.Template)
+	return daemonSet, nil
 }
 
-func (c *Operator) createTPRs() error {
-
-	tpr := &v1beta1.ThirdPartyResource{
-		ObjectMeta: v1.ObjectMeta{
-			Name: tprFlannelNetwork,
-			// TODO some labels could help?
-			// Labels:
-		},
-		Versions: []v1beta1.APIVersion{
-			{Name: v1alpha1.TPRVersion},
-		},
-		Description: "Flannel-based container network connectivity",
+// serverFlanneldOptions are the flanneld flags of the flannel-server.
+func (c *Operator) serverFlanneldOptions() flanneldOptions {
+	o := flanneldOptions{
+		Listen:        envRef("HOST_PUBLIC_IP") + ":8889",
+		EtcdEndpoints: c.config.EtcdEndpoints,
+		EtcdPrefix:    c.config.EtcdPrefix,
+		IPMasq:        c.config.IPMasq,
 	}
-
-	tprClient := c.kclient.ExtensionsClient.ThirdPartyResources()
-
-	if _, err := tprClient.Create(tpr); err != nil && !errors.IsAlreadyExists(err) {
-		return err
+	if len(o.EtcdEndpoints) == 0 {
+		o.EtcdEndpoints = []string{"http://" + envRef("HOST_PUBLIC_IP") + ":2379"}
 	}
-	log.Notice("TPR created:", tpr.Name)
-	return nil
+	if c.config.EtcdTLSSecret != "" {
+		o.EtcdCAFile = etcdTLSDir + "/ca.crt"
+		o.EtcdCertFile = etcdTLSDir + "/tls.crt"
+		o.EtcdKeyFile = etcdTLSDir + "/tls.key"
+	}
+	if c.config.RemoteTLS {
+		setRemoteTLS(&o)
+	}
+	return o
 }
 
-func (c *Operator) deleteTPRs() error {
-	log.Notice("Deleting TPR", tprFlannelNetwork)
-
-	tprClient := c.kclient.ExtensionsClient.ThirdPartyResources()
-
-	return tprClient.Delete(tprFlannelNetwork, &api.DeleteOptions{})
+// clientFlanneldOptions are the flanneld flags of the flannel client of the
+// network.
+func (c *Operator) clientFlanneldOptions(flan *v1alpha1.FlannelNetwork) flanneldOptions {
+	o := flanneldOptions{
+		Remote:    envRef("NODE_IP") + ":8889",
+		Networks:  []string{networkConfigKey(flan)},
+		PublicIP:  envRef("NODE_IP"),
+		Iface:     envRef("NODE_IP"),
+		IPMasq:    c.ipMasq(flan),
+		Verbosity: 1,
+	}
+	if c.config.RemoteTLS {
+		setRemoteTLS(&o)
+	}
+	return o
 }
 
 func (c *Operator) handleAddFlannelNetwork(obj interface{}) {
+	if !c.beginReconcile() {
+		return
+	}
+	defer c.inflight.Done()
 
 	flan := obj.(*v1alpha1.FlannelNetwork)
 	vni := flan.Spec.VNI
 	cidr := flan.Spec.Cidr
 
-	log.Notice("FlannelNetwork added (ns", flan.Namespace, " | VNI", vni, "| CIDR", cidr, ")")
-	log.Notice("Creating deployment of new flannel client")
+	log.Notice(networkKind(flan), "added (ns", flan.Namespace, " | VNI", vni, "| CIDR", cidr, ")")
+
+	c.reconcileFlannelNetwork(flan)
+	c.syncNetworkConfigs()
+}
+
+// reconcileFlannelNetwork brings the network config and the flannel client
+// of the network in line with its spec.
+func (c *Operator) reconcileFlannelNetwork(flan *v1alpha1.FlannelNetwork) {
+	if err := c.syncStatus(flan); err != nil {
+		log.Error("Updating status of", networkKind(flan), networkKey(flan), "failed:", err)
+	}
+	if flan.Spec.Paused {
+		log.Notice(networkKind(flan), networkKey(flan), "is paused, leaving its objects alone")
+		return
+	}
+
+	if err := c.checkNetwork(flan); err != nil {
+		log.Error("Ignoring", networkKind(flan), networkKey(flan), "-", err)
+		return
+	}
+
+	if err := c.netStore.PutNetworkConfig(flan); err != nil {
+		log.Error("Storing network config failed:", err)
+	}
+
+	log.Notice("Creating deployment of flannel client")
+
+	depl, err := c.newClientDeployment(flan)
+	if err != nil {
+		log.Error("Creating deployment failed:", err)
+		return
+	}
+	if err := c.createOrUpdateDeployment(depl); err != nil {
+		log.Error("Creating deployment failed:", err)
+	} else {
+		log.Notice("Deployment for flannel client created")
+	}
+}
+
+// newClientDeployment returns the desired Deployment of the flannel client
+// of the network.
+func (c *Operator) newClientDeployment(flan *v1alpha1.FlannelNetwork) (*v1beta1.Deployment, error) {
+	vni := flan.Spec.VNI
 
 	var replicas int32 = 1
 	var privileged bool = true
 
 	depl := &v1beta1.Deployment{
 		ObjectMeta: v1.ObjectMeta{
-			Name: "flannel-client-" + flan.Namespace + "-" + flan.Name + "-vni" + vni,
+			Name: clientDeploymentName(flan),
 			Labels: map[string]string{
 				"app": "flannel-client",
 				"vni": vni,
@@ -354,8 +805,9 @@ func (c *Operator) handleAddFlannelNetwork(obj interface{}) {
 							SecurityContext: &v1.SecurityContext{
 								Privileged: &privileged,
 							},
-							Image:           "giantswarm/flannel:v0.6.2",
+							Image:           flannelImage + ":" + c.flannelVersion(flan),
 							ImagePullPolicy: "IfNotPresent",
+							Resources:       c.clientResources(flan),
 							Env: []v1.EnvVar{
 								{
 									Name: "NODE_IP",
@@ -366,11 +818,9 @@ func (c *Operator) handleAddFlannelNetwork(obj interface{}) {
 									},
 								},
 							},
-							Command: []string{
-								"/bin/sh",
-								"-c",
-								"/opt/bin/flanneld --remote=$NODE_IP:8889 --public-ip=$NODE_IP --iface=$NODE_IP --networks=" + vni + " -v=1",
-							},
+							Command:        []string{flanneldPath},
+							Args:           c.clientFlanneldOptions(flan).args(),
+							ReadinessProbe: clientReadinessProbe(flan),
 							VolumeMounts: []v1.VolumeMount{
 								{
 									Name:      "flannel",
@@ -384,23 +834,57 @@ func (c *Operator) handleAddFlannelNetwork(obj interface{}) {
 		},
 	}
 
-	deplClient := c.kclient.Deployments(kubeSystemNamespace)
-	if _, err := deplClient.Create(depl); err != nil {
-		log.Error("Creating deployment failed:", err)
-	} else {
-		log.Notice("Deployment for flannel client created")
+	// The annotation was checked by validateFlannelNetwork.
+	if placement, _ := flannelv1beta1.PlacementFromAnnotations(flan.Annotations); placement != nil {
+		depl.Spec.Template.Spec.NodeSelector = placement.NodeSelector
+	}
+	if c.config.Datastore == DatastoreKubernetes {
+		c.useKubernetesDatastore(depl, flan)
+	}
+	if c.config.RemoteTLS {
+		podSpec := &depl.Spec.Template.Spec
+		volume, mount := remoteTLSVolume(clientSecretName)
+		podSpec.Volumes = append(podSpec.Volumes, volume)
+		podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, mount)
+		hash, err := c.tlsHash(clientSecretName)
+		if err != nil {
+			return nil, err
+		}
+		depl.Spec.Template.Annotations[tlsHashAnnotation] = hash
 	}
 
+	if err := applyPodTemplateOverrides(&depl.Spec.Template, c.config.PodTemplateOverrides, flan.Spec.PodTemplateOverrides); err != nil {
+		return nil, err
+	}
+	return depl, nil
 }
 
+func (c *Operator) handleUpdateFlannelNetwork(old, cur interface{}) {
+	if !c.beginReconcile() {
+		return
+	}
+	defer c.inflight.Done()
+
+	flan := cur.(*v1alpha1.FlannelNetwork)
+
+	log.Notice(networkKind(flan), "updated (ns", flan.Namespace, "| name", flan.Name, ")")
+
+	c.reconcileFlannelNetwork(flan)
+	c.syncNetworkConfigs()
+}
 
 func (c *Operator) handleDeleteFlannelNetwork(obj interface{}) {
+	if !c.beginReconcile() {
+		return
+	}
+	defer c.inflight.Done()
+
 	flan := obj.(*v1alpha1.FlannelNetwork)
 	vni := flan.Spec.VNI
 	cidr := flan.Spec.Cidr
 
 	log.Notice("handleDeleteFlannelNetwork (VNI ", vni, ", CIDR", cidr, ")")
-	deploymentClient := c.kclient.Deployments(kubeSystemNamespace)
+	deploymentClient := c.kclient.Extensions().Deployments(kubeSystemNamespace)
 
 	// remove all the pods, not only the Deployment
 	var orphan bool = false
@@ -408,13 +892,142 @@ func (c *Operator) handleDeleteFlannelNetwork(obj interface{}) {
 		OrphanDependents: &orphan,
 	}
 
-	if err := deploymentClient.Delete(clientDeploymentName(flan), deleteOptions); err != nil {
-		log.Error("Deleting deployment flannel-client failed:", err)
-	} else {
-		log.Notice("Deleted deployment flannel-client")
+	if !c.dryRun(planDelete, "Deployment", kubeSystemNamespace, clientDeploymentName(flan), nil, nil) {
+		if err := deploymentClient.Delete(clientDeploymentName(flan), deleteOptions); err != nil {
+			log.Error("Deleting deployment flannel-client failed:", err)
+		} else {
+			log.Notice("Deleted deployment flannel-client")
+		}
+	}
+
+	if owner := c.networkConfigOwner(flan); owner != nil {
+		log.Notice("Keeping network config", networkConfigKey(flan), "of", networkKind(owner), networkKey(owner))
+	} else if err := c.netStore.DeleteNetworkConfig(flan); err != nil {
+		log.Error("Deleting network config failed:", err)
 	}
+
+	c.syncNetworkConfigs()
+}
+
+// networkConfigOwner returns another network with the same network config
+// as flan, if there is one. Of two networks with the same VNI, only the
+// older one is run, see checkNetworkConflicts, and deleting either of them
+// must leave the config alone.
+func (c *Operator) networkConfigOwner(flan *v1alpha1.FlannelNetwork) *v1alpha1.FlannelNetwork {
+	for _, other := range c.networks() {
+		if networkKey(other) != networkKey(flan) && networkConfigKey(other) == networkConfigKey(flan) {
+			return other
+		}
+	}
+	return nil
+}
+
+// checkNetwork tells why the operator ignores the network, if it does.
+func (c *Operator) checkNetwork(flan *v1alpha1.FlannelNetwork) error {
+	if err := validateFlannelNetwork(flan, c.config.Datastore); err != nil {
+		return fmt.Errorf("invalid: %s", err)
+	}
+	if err := c.checkKubernetesDatastore(flan); err != nil {
+		return err
+	}
+	return c.checkNetworkConflicts(flan)
+}
+
+// syncStatus records in the status whether the network is paused and how
+// far its flannel client is rolled out. Changes of the Deployment are picked
+// up on the next resync.
+func (c *Operator) syncStatus(flan *v1alpha1.FlannelNetwork) error {
+	status := v1alpha1.FlannelNetworkStatus{Paused: flan.Spec.Paused}
+
+	depl, err := c.kclient.Extensions().Deployments(kubeSystemNamespace).Get(clientDeploymentName(flan))
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("get deployment: %s", err)
+	}
+	if err == nil {
+		desired := int32(1)
+		if depl.Spec.Replicas != nil {
+			desired = *depl.Spec.Replicas
+		}
+		status.Replicas = depl.Status.Replicas
+		status.UpdatedReplicas = depl.Status.UpdatedReplicas
+		status.AvailableReplicas = depl.Status.AvailableReplicas
+		status.UnavailableReplicas = depl.Status.UnavailableReplicas
+		status.Ready = desired > 0 &&
+			depl.Status.UpdatedReplicas == desired &&
+			depl.Status.AvailableReplicas == desired
+	}
+	status.DiagnosedNodes, status.AttachedNodes, status.DiagnosticsMessage = c.diagnoseNetwork(flan)
+	if err := c.checkNetwork(flan); err != nil {
+		status.Message = err.Error()
+	}
+	if flan.Status != nil && *flan.Status == status {
+		return nil
+	}
+
+	// The object is shared with the informer cache, so it must not be
+	// modified in place.
+	updated := flan.DeepCopy()
+	updated.Status = &status
+	if c.dryRun(planUpdate, networkKind(flan), flan.Namespace, flan.Name, flan.Status, &status) {
+		return nil
+	}
+	return c.updateNetworkStatus(updated)
+}
+
+// syncNetworkConfigs updates everything that is derived from the set of all
+// FlannelNetworks rather than from a single one. Run calls it once the
+// caches have synced.
+func (c *Operator) syncNetworkConfigs() {
+	// Rendered from a partial cache, the configs would drop the networks
+	// not listed yet from the nodes.
+	if !c.cachesSynced() {
+		return
+	}
+	if err := c.syncCNIConfig(); err != nil {
+		log.Error("Syncing CNI config failed:", err)
+	}
+	if err := c.syncPolicyRules(); err != nil {
+		log.Error("Syncing isolation rules failed:", err)
+	}
+}
+
+// cachesSynced tells whether the networks and namespaces are completely
+// known.
+func (c *Operator) cachesSynced() bool {
+	return c.networksSynced() && c.nsInf.HasSynced()
+}
+
+// ipMasq tells whether traffic leaving the network is masqueraded.
+func (c *Operator) ipMasq(flan *v1alpha1.FlannelNetwork) bool {
+	if flan.Spec.IPMasq != nil {
+		return *flan.Spec.IPMasq
+	}
+	return c.config.IPMasq
+}
+
+// flannelVersion returns the flannel release the client of the network runs.
+func (c *Operator) flannelVersion(flan *v1alpha1.FlannelNetwork) string {
+	if flan.Spec.FlannelVersion != "" {
+		return flan.Spec.FlannelVersion
+	}
+	return c.config.FlannelVersion
+}
+
+// mtu returns the MTU of the pod interfaces of the network.
+func (c *Operator) mtu(flan *v1alpha1.FlannelNetwork) int {
+	if flan.Spec.MTU != 0 {
+		return flan.Spec.MTU
+	}
+	return c.config.MTU
 }
 
 func clientDeploymentName(flan *v1alpha1.FlannelNetwork) string {
-	return "flannel-client-" + flan.Namespace + "-" + flan.Name + "-vni" + flan.Spec.VNI
+	return "flannel-client-" + objectPrefix(flan) + "-" + flan.Name + "-vni" + flan.Spec.VNI
+}
+
+// subnetFilePath is where the flannel client of the network writes its
+// lease on the host. flanneld names the file after the network, see
+// networkConfigKey.
+func subnetFilePath(flan *v1alpha1.FlannelNetwork) string {
+	return "/run/flannel/networks/" + networkConfigKey(flan) + ".env"
 }
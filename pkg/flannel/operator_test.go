@@ -0,0 +1,344 @@
+// Copyright 2017 Steffen Gebert
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flannel
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1alpha1"
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1alpha1/fake"
+
+	kfake "k8s.io/client-go/1.5/kubernetes/fake"
+	"k8s.io/client-go/1.5/pkg/api/errors"
+	"k8s.io/client-go/1.5/pkg/api/unversioned"
+	"k8s.io/client-go/1.5/pkg/version"
+)
+
+func vniNetwork(namespace, name, vni, cidr string) *v1alpha1.FlannelNetwork {
+	flan := testNetwork(namespace, name, cidr)
+	flan.Spec.VNI = vni
+	return flan
+}
+
+// newTestOperator returns an operator on top of fake clients holding the
+// networks. The informers aren't run, the networks are put into their
+// stores right away.
+func newTestOperator(t *testing.T, conf Config, networks ...*v1alpha1.FlannelNetwork) (*Operator, *kfake.Clientset, *fake.Clientset) {
+	kclient := kfake.NewSimpleClientset()
+	fclient := fake.NewSimpleClientset()
+	op, err := NewWithClients(kclient, fclient, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, flan := range networks {
+		if isClusterNetwork(flan) {
+			if _, err := fclient.ClusterFlannelNetworks().Create(v1alpha1.ClusterFlannelNetworkFrom(flan)); err != nil {
+				t.Fatal(err)
+			}
+			op.clusterInf.GetStore().Add(v1alpha1.ClusterFlannelNetworkFrom(flan))
+			continue
+		}
+		if _, err := fclient.FlannelNetworks(flan.Namespace).Create(flan); err != nil {
+			t.Fatal(err)
+		}
+		op.flanInf.GetStore().Add(flan)
+	}
+	return op, kclient, fclient
+}
+
+func TestCreateDaemonSet(t *testing.T) {
+	op, kclient, _ := newTestOperator(t, Config{IPMasq: true})
+
+	if err := op.createDaemonSet(); err != nil {
+		t.Fatal(err)
+	}
+	ds, err := kclient.Extensions().DaemonSets(kubeSystemNamespace).Get(dsetFlannelName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := ds.Spec.Template.Spec.Containers[0]
+	if want := flannelImage + ":" + defaultFlannelVersion; server.Image != want {
+		t.Errorf("got image %s, want %s", server.Image, want)
+	}
+	if want := op.serverFlanneldOptions().args(); !reflect.DeepEqual(server.Args, want) {
+		t.Errorf("got args %q, want %q", server.Args, want)
+	}
+	if ds.Spec.Template.Labels[templateHashLabel] == "" {
+		t.Errorf("pod template has no %s label", templateHashLabel)
+	}
+	if _, err := kclient.Core().ConfigMaps(kubeSystemNamespace).Get(policyConfigMapName); err != nil {
+		t.Errorf("policy ConfigMap: %s", err)
+	}
+
+	// An existing DaemonSet is left to the rollout.
+	if err := op.createDaemonSet(); err != nil {
+		t.Errorf("second createDaemonSet: %s", err)
+	}
+}
+
+func TestFlannelNetworkLifecycle(t *testing.T) {
+	flan := vniNetwork("tenant-a", "web", "5", "10.5.0.0/16")
+	op, kclient, fclient := newTestOperator(t, Config{IPMasq: true}, flan)
+	deplClient := kclient.Extensions().Deployments(kubeSystemNamespace)
+	name := clientDeploymentName(flan)
+
+	op.handleAddFlannelNetwork(flan)
+
+	depl, err := deplClient.Get(name)
+	if err != nil {
+		t.Fatalf("client deployment: %s", err)
+	}
+	client := depl.Spec.Template.Spec.Containers[0]
+	if want := flannelImage + ":" + defaultFlannelVersion; client.Image != want {
+		t.Errorf("got image %s, want %s", client.Image, want)
+	}
+	if want := op.clientFlanneldOptions(flan).args(); !reflect.DeepEqual(client.Args, want) {
+		t.Errorf("got args %q, want %q", client.Args, want)
+	}
+	if depl.Labels["vni"] != "5" {
+		t.Errorf("got vni label %q, want 5", depl.Labels["vni"])
+	}
+
+	stored, err := fclient.FlannelNetworks(flan.Namespace).Get(flan.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status == nil {
+		t.Fatal("no status written")
+	}
+	if stored.Status.Paused || stored.Status.Ready {
+		t.Errorf("got status %+v, want neither paused nor ready", *stored.Status)
+	}
+
+	updated := stored.DeepCopy()
+	updated.Spec.FlannelVersion = "v0.7.0"
+	op.handleUpdateFlannelNetwork(stored, updated)
+
+	depl, err = deplClient.Get(name)
+	if err != nil {
+		t.Fatalf("client deployment: %s", err)
+	}
+	if image := depl.Spec.Template.Spec.Containers[0].Image; image != flannelImage+":v0.7.0" {
+		t.Errorf("got image %s after the update, want %s:v0.7.0", image, flannelImage)
+	}
+
+	op.handleDeleteFlannelNetwork(updated)
+
+	if _, err := deplClient.Get(name); !errors.IsNotFound(err) {
+		t.Errorf("client deployment still there after the delete: %v", err)
+	}
+}
+
+func TestPausedFlannelNetwork(t *testing.T) {
+	flan := vniNetwork("tenant-a", "web", "5", "10.5.0.0/16")
+	flan.Spec.Paused = true
+	op, kclient, fclient := newTestOperator(t, Config{}, flan)
+
+	op.handleAddFlannelNetwork(flan)
+
+	if _, err := kclient.Extensions().Deployments(kubeSystemNamespace).Get(clientDeploymentName(flan)); !errors.IsNotFound(err) {
+		t.Errorf("client deployment of a paused network: %v", err)
+	}
+	stored, err := fclient.FlannelNetworks(flan.Namespace).Get(flan.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status == nil || !stored.Status.Paused {
+		t.Errorf("got status %+v, want paused", stored.Status)
+	}
+}
+
+func TestInvalidFlannelNetwork(t *testing.T) {
+	flan := vniNetwork("tenant-a", "web", "0", "10.5.0.0/16")
+	op, kclient, _ := newTestOperator(t, Config{}, flan)
+
+	op.handleAddFlannelNetwork(flan)
+
+	if _, err := kclient.Extensions().Deployments(kubeSystemNamespace).Get(clientDeploymentName(flan)); !errors.IsNotFound(err) {
+		t.Errorf("client deployment of an invalid network: %v", err)
+	}
+}
+
+// recordingNetworkStore keeps the network configs in memory.
+type recordingNetworkStore map[string]string
+
+func (s recordingNetworkStore) PutNetworkConfig(flan *v1alpha1.FlannelNetwork) error {
+	s[networkConfigKey(flan)] = networkKey(flan)
+	return nil
+}
+
+func (s recordingNetworkStore) DeleteNetworkConfig(flan *v1alpha1.FlannelNetwork) error {
+	delete(s, networkConfigKey(flan))
+	return nil
+}
+
+func TestConflictingFlannelNetworks(t *testing.T) {
+	created := time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)
+	older := vniNetwork("tenant-a", "web", "5", "10.5.0.0/16")
+	older.CreationTimestamp = unversioned.NewTime(created)
+	// The same VNI to flannel, written differently.
+	newer := vniNetwork("tenant-b", "web", "05", "10.6.0.0/16")
+	newer.CreationTimestamp = unversioned.NewTime(created.Add(time.Minute))
+	op, kclient, fclient := newTestOperator(t, Config{}, older, newer)
+	store := recordingNetworkStore{}
+	op.netStore = store
+	deplClient := kclient.Extensions().Deployments(kubeSystemNamespace)
+
+	// The order of the events doesn't matter, the older network wins.
+	op.handleAddFlannelNetwork(newer)
+	op.handleAddFlannelNetwork(older)
+
+	if _, err := deplClient.Get(clientDeploymentName(older)); err != nil {
+		t.Errorf("client deployment of the older network: %s", err)
+	}
+	if _, err := deplClient.Get(clientDeploymentName(newer)); !errors.IsNotFound(err) {
+		t.Errorf("client deployment of the newer network: %v", err)
+	}
+	if want := (recordingNetworkStore{"5": networkKey(older)}); !reflect.DeepEqual(store, want) {
+		t.Errorf("got network configs %v, want %v", store, want)
+	}
+	for _, tt := range []struct {
+		flan    *v1alpha1.FlannelNetwork
+		wantMsg bool
+	}{
+		{older, false},
+		{newer, true},
+	} {
+		stored, err := fclient.FlannelNetworks(tt.flan.Namespace).Get(tt.flan.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Status == nil || (stored.Status.Message != "") != tt.wantMsg {
+			t.Errorf("%s: got status %+v, want a message: %v", networkKey(tt.flan), stored.Status, tt.wantMsg)
+		}
+	}
+
+	// Deleting the newer network leaves the config of the older one.
+	op.flanInf.GetStore().Delete(newer)
+	op.handleDeleteFlannelNetwork(newer)
+	if _, ok := store["5"]; !ok {
+		t.Error("network config of the older network deleted along with the newer one")
+	}
+
+	op.flanInf.GetStore().Delete(older)
+	op.handleDeleteFlannelNetwork(older)
+	if len(store) != 0 {
+		t.Errorf("got network configs %v after deleting both networks, want none", store)
+	}
+}
+
+func TestCheckServerVersion(t *testing.T) {
+	tests := []struct {
+		major, minor string
+		want         int
+		wantErr      bool
+	}{
+		{"1", "10", 0, true},
+		{"1", "11", 11, false},
+		{"1", "14+", 14, false},
+		{"1", "15", 15, false},
+		{"1", "16", 0, true},
+		{"", "", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := checkServerVersion(&version.Info{Major: tt.major, Minor: tt.minor})
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("checkServerVersion(%s.%s) = %d, %v, want %d, error %v", tt.major, tt.minor, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestConversionKubeMinor(t *testing.T) {
+	for _, minor := range []int{14, 15} {
+		op, _, _ := newTestOperator(t, Config{WebhookListenAddress: ":8443"})
+		op.kubeMinor = minor
+		want := minor >= conversionKubeMinor
+
+		crd := op.customResourceDefinitions([]byte("ca"))[0]
+		if got := len(crd.Spec.Versions) == 2; got != want {
+			t.Errorf("1.%d: got versions %+v, want v1beta1 served: %v", minor, crd.Spec.Versions, want)
+		}
+		if crd.Spec.Version != v1alpha1.TPRVersion {
+			t.Errorf("1.%d: got version %s, want %s", minor, crd.Spec.Version, v1alpha1.TPRVersion)
+		}
+		wc := op.webhookConfiguration("ValidatingWebhookConfiguration", "validate", "/validate", nil, []byte("ca"))
+		if got := wc.Webhooks[0].MatchPolicy != ""; got != want {
+			t.Errorf("1.%d: got matchPolicy %q, want one: %v", minor, wc.Webhooks[0].MatchPolicy, want)
+		}
+	}
+}
+
+func TestClusterFlannelNetwork(t *testing.T) {
+	flan := vniNetwork("", "shared", "7", "10.7.0.0/16")
+	op, kclient, fclient := newTestOperator(t, Config{}, flan)
+
+	op.handleAddClusterFlannelNetwork(v1alpha1.ClusterFlannelNetworkFrom(flan))
+
+	if _, err := kclient.Extensions().Deployments(kubeSystemNamespace).Get("flannel-client-cluster-shared-vni7"); err != nil {
+		t.Errorf("client deployment: %s", err)
+	}
+	stored, err := fclient.ClusterFlannelNetworks().Get(flan.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status == nil {
+		t.Error("no status written")
+	}
+}
+
+func TestAdmissionAllocation(t *testing.T) {
+	op, _, _ := newTestOperator(t, Config{},
+		vniNetwork("tenant-a", "web", "1", "10.1.0.0/16"),
+		vniNetwork("", "shared", "2", "10.2.0.0/16"),
+	)
+
+	patch, err := op.mutateAdmission(&admissionRequest{
+		Operation: admissionCreate,
+		Object:    json.RawMessage(`{"metadata":{"namespace":"tenant-b","name":"web"},"spec":{"cidr":"10.3.0.0"}}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"vni":"3"`, `"cidr":"10.3.0.0/16"`, `"mtu":1450`} {
+		if !strings.Contains(string(patch), want) {
+			t.Errorf("patch %s lacks %s", patch, want)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		object  string
+		wantErr bool
+	}{
+		{"free", `{"metadata":{"namespace":"tenant-b","name":"web"},"spec":{"vni":"3","cidr":"10.3.0.0/16"}}`, false},
+		{"VNI taken", `{"metadata":{"namespace":"tenant-b","name":"web"},"spec":{"vni":"1","cidr":"10.3.0.0/16"}}`, true},
+		{"VNI of a cluster network taken", `{"metadata":{"namespace":"tenant-b","name":"web"},"spec":{"vni":"2","cidr":"10.3.0.0/16"}}`, true},
+		{"CIDR overlaps", `{"metadata":{"namespace":"tenant-b","name":"web"},"spec":{"vni":"3","cidr":"10.2.128.0/17"}}`, true},
+	}
+	for _, tt := range tests {
+		err := op.validateAdmission(&admissionRequest{
+			Operation: admissionCreate,
+			Object:    json.RawMessage(tt.object),
+		})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateAdmission() = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
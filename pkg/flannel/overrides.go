@@ -0,0 +1,152 @@
+// Copyright 2017 Steffen Gebert
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flannel
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1alpha1"
+
+	"k8s.io/client-go/1.5/pkg/api/v1"
+	"k8s.io/client-go/1.5/pkg/util/strategicpatch"
+)
+
+// imageRegistryRe matches registry hosts with an optional port and path,
+// e.g. registry.example.com:5000/mirror.
+var imageRegistryRe = regexp.MustCompile(`^[a-z0-9.-]+(:[0-9]+)?(/[a-z0-9._-]+)*$`)
+
+// reservedLabels are the pod labels the operator selects its pods by.
+var reservedLabels = []string{"app", "vni", "version", templateHashLabel}
+
+// reservedContainers are the names of the operator's containers, which
+// sidecars must not replace.
+var reservedContainers = []string{dsetFlannelName, "flannel-policy", "k8s-flannel", "install-cni", diagnosticsName}
+
+// validatePodTemplateOverrides checks the overrides before they are merged
+// onto a pod template.
+func validatePodTemplateOverrides(o *v1alpha1.PodTemplateOverrides) error {
+	if o == nil {
+		return nil
+	}
+	for _, l := range reservedLabels {
+		if _, ok := o.Labels[l]; ok {
+			return fmt.Errorf("label %q is managed by the operator", l)
+		}
+	}
+	for _, s := range o.ImagePullSecrets {
+		if s == "" {
+			return fmt.Errorf("empty image pull secret name")
+		}
+	}
+	if o.ImageRegistry != "" && !imageRegistryRe.MatchString(o.ImageRegistry) {
+		return fmt.Errorf("invalid image registry %q", o.ImageRegistry)
+	}
+	for _, s := range o.Sidecars {
+		if s.Name == "" {
+			return fmt.Errorf("sidecar without a name")
+		}
+		for _, name := range reservedContainers {
+			if s.Name == name {
+				return fmt.Errorf("sidecar %q is named like a container of the operator", s.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// applyPodTemplateOverrides merges the overrides onto the template in order,
+// with the strategic merge patch semantics of kubectl apply: labels and
+// annotations are added, image pull secrets and sidecars are merged by name.
+// Sidecars are added next to the operator's containers, see
+// validatePodTemplateOverrides.
+func applyPodTemplateOverrides(template *v1.PodTemplateSpec, overrides ...*v1alpha1.PodTemplateOverrides) error {
+	registry := ""
+	for _, o := range overrides {
+		if o == nil {
+			continue
+		}
+		if o.ImageRegistry != "" {
+			registry = o.ImageRegistry
+		}
+
+		original, err := json.Marshal(template)
+		if err != nil {
+			return err
+		}
+		patch, err := json.Marshal(podTemplatePatch(o))
+		if err != nil {
+			return err
+		}
+		merged, err := strategicpatch.StrategicMergePatch(original, patch, v1.PodTemplateSpec{})
+		if err != nil {
+			return fmt.Errorf("merge pod template overrides: %s", err)
+		}
+		var result v1.PodTemplateSpec
+		if err := json.Unmarshal(merged, &result); err != nil {
+			return err
+		}
+		*template = result
+	}
+
+	if registry != "" {
+		for i := range template.Spec.Containers {
+			template.Spec.Containers[i].Image = mirrorImage(template.Spec.Containers[i].Image, registry)
+		}
+	}
+	return nil
+}
+
+// podTemplatePatch is the strategic merge patch of the overrides. It is
+// built as a map, since a v1.PodTemplateSpec would clear the containers.
+func podTemplatePatch(o *v1alpha1.PodTemplateOverrides) map[string]interface{} {
+	meta := map[string]interface{}{}
+	if len(o.Labels) > 0 {
+		meta["labels"] = o.Labels
+	}
+	if len(o.Annotations) > 0 {
+		meta["annotations"] = o.Annotations
+	}
+
+	spec := map[string]interface{}{}
+	if len(o.ImagePullSecrets) > 0 {
+		var refs []v1.LocalObjectReference
+		for _, name := range o.ImagePullSecrets {
+			refs = append(refs, v1.LocalObjectReference{Name: name})
+		}
+		spec["imagePullSecrets"] = refs
+	}
+	if len(o.Sidecars) > 0 {
+		spec["containers"] = o.Sidecars
+	}
+
+	return map[string]interface{}{
+		"metadata": meta,
+		"spec":     spec,
+	}
+}
+
+// mirrorImage replaces the registry of the image. Like docker, the first
+// path component is taken as the registry if it has a dot or a port or is
+// localhost.
+func mirrorImage(image, registry string) string {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		image = parts[1]
+	}
+	return registry + "/" + image
+}
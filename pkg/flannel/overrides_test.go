@@ -0,0 +1,126 @@
+// Copyright 2017 Steffen Gebert
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flannel
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1alpha1"
+
+	"k8s.io/client-go/1.5/pkg/api/v1"
+)
+
+func TestValidatePodTemplateOverrides(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides *v1alpha1.PodTemplateOverrides
+		wantErr   bool
+	}{
+		{
+			name:      "none",
+			overrides: nil,
+		},
+		{
+			name: "sidecar",
+			overrides: &v1alpha1.PodTemplateOverrides{
+				Sidecars: []v1.Container{{Name: "log-shipper", Image: "fluent/fluent-bit:0.11"}},
+			},
+		},
+		{
+			name: "reserved label",
+			overrides: &v1alpha1.PodTemplateOverrides{
+				Labels: map[string]string{"app": "other"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "sidecar without a name",
+			overrides: &v1alpha1.PodTemplateOverrides{
+				Sidecars: []v1.Container{{Image: "fluent/fluent-bit:0.11"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "sidecar named like the flannel-server",
+			overrides: &v1alpha1.PodTemplateOverrides{
+				Sidecars: []v1.Container{{Name: "flannel-server", Image: "evil"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "sidecar named like the flannel client",
+			overrides: &v1alpha1.PodTemplateOverrides{
+				Sidecars: []v1.Container{{Name: "k8s-flannel", Image: "evil"}},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		err := validatePodTemplateOverrides(tt.overrides)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error: %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestApplyPodTemplateOverridesSidecars(t *testing.T) {
+	template := &v1.PodTemplateSpec{
+		ObjectMeta: v1.ObjectMeta{
+			Labels: map[string]string{"app": "flannel-client"},
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{Name: "k8s-flannel", Image: "quay.io/coreos/flannel:v0.10.0"},
+			},
+		},
+	}
+	operator := &v1alpha1.PodTemplateOverrides{
+		Labels: map[string]string{"team": "platform"},
+		Sidecars: []v1.Container{
+			{Name: "log-shipper", Image: "fluent/fluent-bit:0.11"},
+		},
+	}
+	network := &v1alpha1.PodTemplateOverrides{
+		Sidecars: []v1.Container{
+			// Merged into the sidecar of the operator's overrides.
+			{Name: "log-shipper", Args: []string{"-v"}},
+			{Name: "metrics", Image: "prom/statsd-exporter"},
+		},
+		ImageRegistry: "registry.example.com:5000",
+	}
+
+	if err := applyPodTemplateOverrides(template, operator, network); err != nil {
+		t.Fatal(err)
+	}
+
+	wantLabels := map[string]string{"app": "flannel-client", "team": "platform"}
+	if !reflect.DeepEqual(template.Labels, wantLabels) {
+		t.Errorf("got labels %v, want %v", template.Labels, wantLabels)
+	}
+	got := map[string]v1.Container{}
+	for _, c := range template.Spec.Containers {
+		got[c.Name] = c
+	}
+	want := map[string]v1.Container{
+		"k8s-flannel": {Name: "k8s-flannel", Image: "registry.example.com:5000/coreos/flannel:v0.10.0"},
+		"log-shipper": {Name: "log-shipper", Image: "registry.example.com:5000/fluent/fluent-bit:0.11", Args: []string{"-v"}},
+		"metrics":     {Name: "metrics", Image: "registry.example.com:5000/prom/statsd-exporter"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got containers %+v, want %+v", got, want)
+	}
+}
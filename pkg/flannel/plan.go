@@ -0,0 +1,217 @@
+// Copyright 2017 Steffen Gebert
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flannel
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1alpha1"
+
+	"k8s.io/client-go/1.5/pkg/api/v1"
+)
+
+const (
+	planCreate = "create"
+	planUpdate = "update"
+	planDelete = "delete"
+)
+
+// planAction is a write the operator would have done if it wasn't running
+// in dry-run mode.
+type planAction struct {
+	Time      time.Time `json:"time"`
+	Verb      string    `json:"verb"`
+	Kind      string    `json:"kind"`
+	Namespace string    `json:"namespace,omitempty"`
+	Name      string    `json:"name"`
+	Diff      string    `json:"diff,omitempty"`
+}
+
+// planRecorder collects the actions of a dry run. It replaces all writes
+// to the Kubernetes API and etcd.
+type planRecorder struct {
+	mtx     sync.Mutex
+	actions []planAction
+	// last holds the last verb and diff recorded per object, as resyncs
+	// keep repeating the same actions.
+	last map[string]string
+}
+
+func newPlanRecorder() *planRecorder {
+	return &planRecorder{last: map[string]string{}}
+}
+
+// record adds an action to the plan. Updates that don't change anything
+// and repetitions of the previous action on the object are dropped.
+func (p *planRecorder) record(verb, kind, namespace, name string, old, new interface{}) {
+	diff := diffObjects(old, new)
+	if verb == planUpdate && diff == "" {
+		return
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	key := kind + "/" + namespace + "/" + name
+	if last, ok := p.last[key]; ok && last == verb+"\n"+diff {
+		return
+	}
+	p.last[key] = verb + "\n" + diff
+
+	a := planAction{
+		Time:      time.Now(),
+		Verb:      verb,
+		Kind:      kind,
+		Namespace: namespace,
+		Name:      name,
+		Diff:      diff,
+	}
+	log.Notice("[dry-run] Would", verb, kind, strings.TrimPrefix(namespace+"/"+name, "/"))
+	if diff != "" {
+		log.Info("[dry-run] Diff:\n" + diff)
+	}
+
+	p.actions = append(p.actions, a)
+}
+
+// ServeHTTP returns the plan recorded so far as JSON.
+func (p *planRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Actions []planAction `json:"actions"`
+	}{p.actions})
+}
+
+// dryRun records the write in the plan if the operator runs in dry-run
+// mode, in which case the caller must skip the actual write. old and new
+// are the parts of the object the diff is computed on, either may be nil.
+func (c *Operator) dryRun(verb, kind, namespace, name string, old, new interface{}) bool {
+	if c.plan == nil {
+		return false
+	}
+	c.plan.record(verb, kind, namespace, name, old, new)
+	return true
+}
+
+// diffObjects renders a line diff of the JSON representation of two
+// objects, prefixing removed lines with "-" and added ones with "+".
+func diffObjects(old, new interface{}) string {
+	a, b := objectLines(old), objectLines(new)
+
+	// Longest common subsequence of the lines, objects are small enough.
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	changed := false
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			fmt.Fprintln(&buf, " "+a[i])
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			fmt.Fprintln(&buf, "+"+b[j])
+			changed = true
+			j++
+		default:
+			fmt.Fprintln(&buf, "-"+a[i])
+			changed = true
+			i++
+		}
+	}
+
+	if !changed {
+		return ""
+	}
+	return buf.String()
+}
+
+func objectLines(obj interface{}) []string {
+	if obj == nil {
+		return nil
+	}
+	b, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		return []string{fmt.Sprintf("<%s>", err)}
+	}
+	if string(b) == "null" {
+		return nil
+	}
+	return strings.Split(string(b), "\n")
+}
+
+// redactedSecretData replaces the values of a Secret with a short hash, so
+// the plan shows that they change without leaking them.
+func redactedSecretData(secret *v1.Secret) map[string]string {
+	if secret == nil {
+		return nil
+	}
+	data := map[string]string{}
+	for k, v := range secret.Data {
+		sum := sha256.Sum256(v)
+		data[k] = fmt.Sprintf("<redacted sha256:%x>", sum[:6])
+	}
+	return data
+}
+
+// dryRunEtcdStore records the etcd writes of the network configs in the
+// plan.
+type dryRunEtcdStore struct {
+	c      *Operator
+	prefix string
+}
+
+func (s *dryRunEtcdStore) PutNetworkConfig(flan *v1alpha1.FlannelNetwork) error {
+	b, err := renderNetworkConfig(flan)
+	if err != nil {
+		return err
+	}
+	var config interface{}
+	if err := json.Unmarshal(b, &config); err != nil {
+		return err
+	}
+	s.c.dryRun(planUpdate, "EtcdKey", "", s.prefix+"/"+networkConfigKey(flan)+"/config", nil, config)
+	return nil
+}
+
+func (s *dryRunEtcdStore) DeleteNetworkConfig(flan *v1alpha1.FlannelNetwork) error {
+	s.c.dryRun(planDelete, "EtcdKey", "", s.prefix+"/"+networkConfigKey(flan), nil, nil)
+	return nil
+}
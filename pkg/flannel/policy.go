@@ -0,0 +1,274 @@
+// Copyright 2017 Steffen Gebert
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flannel
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1alpha1"
+
+	"k8s.io/client-go/1.5/pkg/api/errors"
+	"k8s.io/client-go/1.5/pkg/api/v1"
+)
+
+const (
+	policyConfigMapName = "flannel-policy-rules"
+	policyRulesKey      = "rules"
+	policyMTUsKey       = "mtus"
+	// policyNetworksKey keeps the networks the rules were rendered from,
+	// see appliedPolicyNetworks.
+	policyNetworksKey   = "networks"
+	policyChain         = "FLANNEL-ISOLATION"
+	masqExceptionsChain = "FLANNEL-MASQ-EXCEPTIONS"
+)
+
+// policyApplyScript runs in the flannel-policy sidecar of the flannel-server
+// DaemonSet. It hooks the isolation chain into FORWARD and loads the rules
+// from the ConfigMap whenever they change. The masquerade exceptions are
+// hooked in front of flanneld's masquerade rules in nat POSTROUTING.
+// Declaring the chains in the rules flushes them, so stale rules don't
+// survive a reload. It also sets the MTU of the flannel.<vni> devices, which
+// flanneld sizes after the node's interface.
+const policyApplyScript = `
+while true; do
+  iptables -N ` + policyChain + ` 2>/dev/null
+  iptables -C FORWARD -j ` + policyChain + ` 2>/dev/null || iptables -I FORWARD -j ` + policyChain + `
+  iptables -t nat -N ` + masqExceptionsChain + ` 2>/dev/null
+  iptables -t nat -C POSTROUTING -j ` + masqExceptionsChain + ` 2>/dev/null || iptables -t nat -I POSTROUTING -j ` + masqExceptionsChain + `
+  if ! cmp -s /etc/flannel-policy/` + policyRulesKey + ` /tmp/applied; then
+    iptables-restore --noflush < /etc/flannel-policy/` + policyRulesKey + ` && cp /etc/flannel-policy/` + policyRulesKey + ` /tmp/applied
+  fi
+  while read vni mtu; do
+    ip link set dev flannel.$vni mtu $mtu 2>/dev/null
+  done < /etc/flannel-policy/` + policyMTUsKey + `
+  sleep 10
+done
+`
+
+// networkKey identifies a FlannelNetwork across namespaces, and a
+// ClusterFlannelNetwork as /<name>.
+func networkKey(flan *v1alpha1.FlannelNetwork) string {
+	return flan.Namespace + "/" + flan.Name
+}
+
+// peerKey resolves a peer of the network as given in spec.peers.
+func peerKey(flan *v1alpha1.FlannelNetwork, peer string) string {
+	if strings.Contains(peer, "/") {
+		return peer
+	}
+	return flan.Namespace + "/" + peer
+}
+
+type isolatedNetwork struct {
+	key   string
+	cidr  string
+	peers map[string]bool
+}
+
+type networksByKey []*v1alpha1.FlannelNetwork
+
+func (n networksByKey) Len() int           { return len(n) }
+func (n networksByKey) Less(i, j int) bool { return networkKey(n[i]) < networkKey(n[j]) }
+func (n networksByKey) Swap(i, j int)      { n[i], n[j] = n[j], n[i] }
+
+// sortedNetworks returns a copy of the networks ordered by namespace and
+// name, so the rendered rules don't change with the order of the cache.
+func sortedNetworks(networks []*v1alpha1.FlannelNetwork) []*v1alpha1.FlannelNetwork {
+	sorted := make([]*v1alpha1.FlannelNetwork, len(networks))
+	copy(sorted, networks)
+	sort.Sort(networksByKey(sorted))
+	return sorted
+}
+
+// renderIsolationRules renders iptables-restore input for the filter table
+// that drops traffic between the networks, unless the source network lists
+// the destination network as one of its peers. Replies to allowed
+// connections are let through by conntrack. Networks without a valid CIDR
+// can't hand out addresses and are left out.
+func renderIsolationRules(networks []*v1alpha1.FlannelNetwork) string {
+	var nets []isolatedNetwork
+	for _, flan := range sortedNetworks(networks) {
+		_, ipNet, err := net.ParseCIDR(flan.Spec.Cidr)
+		if err != nil {
+			continue
+		}
+		n := isolatedNetwork{
+			key:   networkKey(flan),
+			cidr:  ipNet.String(),
+			peers: map[string]bool{},
+		}
+		for _, p := range flan.Spec.Peers {
+			n.peers[peerKey(flan, p)] = true
+		}
+		nets = append(nets, n)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintln(&b, "*filter")
+	fmt.Fprintf(&b, ":%s - [0:0]\n", policyChain)
+	fmt.Fprintf(&b, "-A %s -m conntrack --ctstate RELATED,ESTABLISHED -j RETURN\n", policyChain)
+	for _, src := range nets {
+		for _, dst := range nets {
+			if src.key == dst.key {
+				continue
+			}
+			target := "DROP"
+			if src.peers[dst.key] {
+				target = "RETURN"
+			}
+			fmt.Fprintf(&b, "-A %s -s %s -d %s -m comment --comment \"%s -> %s\" -j %s\n",
+				policyChain, src.cidr, dst.cidr, src.key, dst.key, target)
+		}
+	}
+	fmt.Fprintln(&b, "COMMIT")
+
+	return b.String()
+}
+
+// renderMasqueradeRules renders iptables-restore input for the nat table
+// that exempts traffic from the networks to their masqueradeExceptions from
+// being masqueraded. Accepting the packets in the chain skips the rest of
+// POSTROUTING, including flanneld's MASQUERADE rules. Only networks that are
+// masqueraded at all must be passed.
+func renderMasqueradeRules(networks []*v1alpha1.FlannelNetwork) string {
+	var b bytes.Buffer
+	fmt.Fprintln(&b, "*nat")
+	fmt.Fprintf(&b, ":%s - [0:0]\n", masqExceptionsChain)
+	for _, flan := range sortedNetworks(networks) {
+		_, ipNet, err := net.ParseCIDR(flan.Spec.Cidr)
+		if err != nil {
+			continue
+		}
+		for _, e := range flan.Spec.MasqueradeExceptions {
+			fmt.Fprintf(&b, "-A %s -s %s -d %s -m comment --comment \"%s\" -j ACCEPT\n",
+				masqExceptionsChain, ipNet.String(), e, networkKey(flan))
+		}
+	}
+	fmt.Fprintln(&b, "COMMIT")
+
+	return b.String()
+}
+
+// renderDeviceMTUs renders the MTU of the flannel.<vni> device of each
+// network as a "<vni> <mtu>" line, so the devices fit the pod interfaces.
+func renderDeviceMTUs(networks []*v1alpha1.FlannelNetwork, mtu func(*v1alpha1.FlannelNetwork) int) string {
+	var b bytes.Buffer
+	for _, flan := range sortedNetworks(networks) {
+		fmt.Fprintf(&b, "%s %d\n", flan.Spec.VNI, mtu(flan))
+	}
+	return b.String()
+}
+
+// appliedPolicyNetworks returns the networks the rules in the ConfigMap were
+// rendered from by networkKey, if any.
+func appliedPolicyNetworks(cm *v1.ConfigMap) map[string]*v1alpha1.FlannelNetwork {
+	if cm == nil {
+		return nil
+	}
+	var networks map[string]*v1alpha1.FlannelNetwork
+	if err := json.Unmarshal([]byte(cm.Data[policyNetworksKey]), &networks); err != nil {
+		return nil
+	}
+	return networks
+}
+
+// policyConfigMap renders the rules of all known FlannelNetworks. Paused
+// networks keep the spec they had in existing, the ConfigMap applied so far,
+// and are only taken as they are if they were never applied.
+func (c *Operator) policyConfigMap(existing *v1.ConfigMap) (*v1.ConfigMap, error) {
+	applied := appliedPolicyNetworks(existing)
+
+	var networks, masqueraded []*v1alpha1.FlannelNetwork
+	snapshot := map[string]*v1alpha1.FlannelNetwork{}
+	for _, flan := range c.networks() {
+		if prev, ok := applied[networkKey(flan)]; ok && flan.Spec.Paused {
+			flan = prev
+		}
+		if c.checkNetwork(flan) != nil {
+			continue
+		}
+		networks = append(networks, flan)
+		if c.ipMasq(flan) {
+			masqueraded = append(masqueraded, flan)
+		}
+		snapshot[networkKey(flan)] = &v1alpha1.FlannelNetwork{
+			ObjectMeta: v1.ObjectMeta{
+				Namespace:         flan.Namespace,
+				Name:              flan.Name,
+				CreationTimestamp: flan.CreationTimestamp,
+			},
+			Spec: flan.Spec,
+		}
+	}
+	b, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("encode applied networks: %s", err)
+	}
+
+	return &v1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      policyConfigMapName,
+			Namespace: kubeSystemNamespace,
+			Labels: map[string]string{
+				"app": dsetFlannelName,
+			},
+		},
+		Data: map[string]string{
+			policyRulesKey:    renderIsolationRules(networks) + renderMasqueradeRules(masqueraded),
+			policyMTUsKey:     renderDeviceMTUs(networks, c.mtu),
+			policyNetworksKey: string(b),
+		},
+	}, nil
+}
+
+// createPolicyConfigMap makes sure the ConfigMap the flannel-policy sidecars
+// depend on exists, without touching rules that are already in place.
+func (c *Operator) createPolicyConfigMap() error {
+	cm, err := c.policyConfigMap(nil)
+	if err != nil {
+		return err
+	}
+	if err := c.createConfigMapIfNotExists(cm); err != nil {
+		return fmt.Errorf("create configmap %s: %s", policyConfigMapName, err)
+	}
+	return nil
+}
+
+// syncPolicyRules renders the isolation rules of all known FlannelNetworks
+// into the ConfigMap the flannel-policy sidecars apply on the nodes. Paused
+// networks keep their last applied rules, as leaving them out would open
+// them up to all other networks.
+func (c *Operator) syncPolicyRules() error {
+	existing, err := c.kclient.Core().ConfigMaps(kubeSystemNamespace).Get(policyConfigMapName)
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("get policy ConfigMap: %s", err)
+	}
+	if err != nil {
+		existing = nil
+	}
+	cm, err := c.policyConfigMap(existing)
+	if err != nil {
+		return err
+	}
+	if err := c.createOrUpdateConfigMap(cm); err != nil {
+		return fmt.Errorf("update policy ConfigMap: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+// Copyright 2017 Steffen Gebert
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flannel
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1alpha1"
+
+	"k8s.io/client-go/1.5/pkg/api/v1"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden files in testdata.")
+
+// checkGolden compares got with testdata/<name>.golden.
+func checkGolden(t *testing.T, name, got string) {
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := ioutil.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("%s: got\n%s\nwant\n%s", name, got, want)
+	}
+}
+
+func testNetwork(namespace, name, cidr string, peers ...string) *v1alpha1.FlannelNetwork {
+	return &v1alpha1.FlannelNetwork{
+		ObjectMeta: v1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: v1alpha1.FlannelNetworkSpec{
+			Cidr:  cidr,
+			Peers: peers,
+		},
+	}
+}
+
+func TestRenderIsolationRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		networks []*v1alpha1.FlannelNetwork
+	}{
+		{
+			name: "isolation-empty",
+		},
+		{
+			name: "isolation-isolated",
+			networks: []*v1alpha1.FlannelNetwork{
+				testNetwork("tenant-b", "web", "10.2.0.0/16"),
+				testNetwork("tenant-a", "web", "10.1.0.0/16"),
+			},
+		},
+		{
+			name: "isolation-peers",
+			networks: []*v1alpha1.FlannelNetwork{
+				// By name in the same namespace and as
+				// <namespace>/<name>.
+				testNetwork("tenant-a", "web", "10.1.0.0/16", "db", "infra/monitoring"),
+				testNetwork("tenant-a", "db", "10.3.0.0/16"),
+				testNetwork("infra", "monitoring", "10.4.0.0/16", "tenant-a/web", "tenant-a/db"),
+			},
+		},
+		{
+			name: "isolation-cluster-peers",
+			networks: []*v1alpha1.FlannelNetwork{
+				testNetwork("tenant-a", "web", "10.1.0.0/16", "/shared"),
+				// ClusterFlannelNetworks have no namespace.
+				testNetwork("", "shared", "10.100.0.0/16", "tenant-a/web"),
+				testNetwork("", "platform", "10.101.0.0/16", "shared"),
+			},
+		},
+		{
+			name: "isolation-invalid-cidr",
+			networks: []*v1alpha1.FlannelNetwork{
+				testNetwork("tenant-a", "web", "10.1.0.0/16"),
+				testNetwork("tenant-a", "broken", "10.2.0.0"),
+				// Host bits are cleared.
+				testNetwork("tenant-b", "web", "10.5.1.0/16"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		checkGolden(t, tt.name, renderIsolationRules(tt.networks))
+	}
+}
+
+func TestRenderMasqueradeRules(t *testing.T) {
+	withExceptions := func(flan *v1alpha1.FlannelNetwork, exceptions ...string) *v1alpha1.FlannelNetwork {
+		flan.Spec.MasqueradeExceptions = exceptions
+		return flan
+	}
+
+	tests := []struct {
+		name     string
+		networks []*v1alpha1.FlannelNetwork
+	}{
+		{
+			name: "masquerade-empty",
+		},
+		{
+			name: "masquerade-exceptions",
+			networks: []*v1alpha1.FlannelNetwork{
+				withExceptions(testNetwork("tenant-b", "web", "10.2.0.0/16"), "192.168.0.0/16"),
+				withExceptions(testNetwork("tenant-a", "web", "10.1.0.0/16"), "10.0.0.0/8", "172.16.0.0/12"),
+				withExceptions(testNetwork("", "shared", "10.100.0.0/16"), "10.0.0.0/8"),
+				testNetwork("tenant-a", "db", "10.3.0.0/16"),
+			},
+		},
+		{
+			name: "masquerade-invalid-cidr",
+			networks: []*v1alpha1.FlannelNetwork{
+				withExceptions(testNetwork("tenant-a", "broken", "10.1.0.0"), "10.0.0.0/8"),
+				withExceptions(testNetwork("tenant-a", "web", "10.2.3.0/16"), "10.0.0.0/8"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		checkGolden(t, tt.name, renderMasqueradeRules(tt.networks))
+	}
+}
+
+func TestRenderDeviceMTUs(t *testing.T) {
+	a := testNetwork("tenant-a", "web", "10.1.0.0/16")
+	a.Spec.VNI = "2"
+	a.Spec.MTU = 1400
+	b := testNetwork("tenant-b", "web", "10.2.0.0/16")
+	b.Spec.VNI = "3"
+	mtu := func(flan *v1alpha1.FlannelNetwork) int {
+		if flan.Spec.MTU != 0 {
+			return flan.Spec.MTU
+		}
+		return defaultMTU
+	}
+
+	got := renderDeviceMTUs([]*v1alpha1.FlannelNetwork{b, a}, mtu)
+	want := "2 1400\n3 1450\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2017 Steffen Gebert
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flannel
+
+import (
+	"fmt"
+
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1alpha1"
+
+	"k8s.io/client-go/1.5/pkg/api/resource"
+	"k8s.io/client-go/1.5/pkg/api/v1"
+)
+
+// QoS classes a flannel pod can be configured for.
+const (
+	qosGuaranteed = "Guaranteed"
+	qosBurstable  = "Burstable"
+	qosBestEffort = "BestEffort"
+)
+
+// validateResources checks the resource names and quantities and that they
+// give the pod the requested QoS class.
+func validateResources(r v1alpha1.ResourceRequirements) error {
+	for _, list := range []map[string]string{r.Requests, r.Limits} {
+		for name, value := range list {
+			if v1.ResourceName(name) != v1.ResourceCPU && v1.ResourceName(name) != v1.ResourceMemory {
+				return fmt.Errorf("unsupported resource %q, only cpu and memory are supported", name)
+			}
+			if _, err := resource.ParseQuantity(value); err != nil {
+				return fmt.Errorf("invalid quantity %q for %s: %s", value, name, err)
+			}
+		}
+	}
+	for name, value := range r.Requests {
+		limit, ok := r.Limits[name]
+		if !ok {
+			continue
+		}
+		if q := resource.MustParse(value); q.Cmp(resource.MustParse(limit)) > 0 {
+			return fmt.Errorf("%s request %s exceeds the limit %s", name, value, limit)
+		}
+	}
+
+	switch r.QOSClass {
+	case "":
+	case qosGuaranteed:
+		for _, name := range []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory} {
+			if _, ok := r.Limits[string(name)]; !ok {
+				return fmt.Errorf("QoS class %s needs a %s limit", qosGuaranteed, name)
+			}
+		}
+		for name, value := range r.Requests {
+			if q := resource.MustParse(value); q.Cmp(resource.MustParse(r.Limits[name])) != 0 {
+				return fmt.Errorf("QoS class %s needs the %s request to equal the limit", qosGuaranteed, name)
+			}
+		}
+	case qosBurstable:
+		if len(r.Requests) == 0 && len(r.Limits) == 0 {
+			return fmt.Errorf("QoS class %s needs a request or limit", qosBurstable)
+		}
+	case qosBestEffort:
+		if len(r.Requests) > 0 || len(r.Limits) > 0 {
+			return fmt.Errorf("QoS class %s allows no requests or limits", qosBestEffort)
+		}
+	default:
+		return fmt.Errorf("unknown QoS class %q", r.QOSClass)
+	}
+	return nil
+}
+
+// resourceRequirements returns the container resources for r, which must
+// have passed validateResources. For the Guaranteed class, the requests are
+// set to the limits like the apiserver would default them.
+func resourceRequirements(r v1alpha1.ResourceRequirements) v1.ResourceRequirements {
+	requests := r.Requests
+	if r.QOSClass == qosGuaranteed {
+		requests = r.Limits
+	}
+	return v1.ResourceRequirements{
+		Requests: resourceList(requests),
+		Limits:   resourceList(r.Limits),
+	}
+}
+
+func resourceList(quantities map[string]string) v1.ResourceList {
+	if len(quantities) == 0 {
+		return nil
+	}
+	list := v1.ResourceList{}
+	for name, value := range quantities {
+		list[v1.ResourceName(name)] = resource.MustParse(value)
+	}
+	return list
+}
+
+// clientResources returns the resources of the flannel client container of
+// the network. The network's resources replace the operator's as a whole.
+func (c *Operator) clientResources(flan *v1alpha1.FlannelNetwork) v1.ResourceRequirements {
+	if flan.Spec.Resources != nil {
+		return resourceRequirements(*flan.Spec.Resources)
+	}
+	return resourceRequirements(c.config.ClientResources)
+}
@@ -0,0 +1,206 @@
+// Copyright 2017 Steffen Gebert
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flannel
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/client-go/1.5/pkg/api"
+	"k8s.io/client-go/1.5/pkg/api/errors"
+	"k8s.io/client-go/1.5/pkg/api/v1"
+	"k8s.io/client-go/1.5/pkg/labels"
+)
+
+const (
+	// cniCleanupWait is how long the CNI installers get to remove the
+	// conflist and plugin from the nodes: the kubelet takes up to a minute to
+	// update the ConfigMap volume, plus the 10s loop of cniInstallScript.
+	cniCleanupWait = 90 * time.Second
+
+	// operatorStopTimeout is how long the pods of the running operator get
+	// to go away, enough for Stop to drain.
+	operatorStopTimeout  = 2 * time.Minute
+	operatorPollInterval = 2 * time.Second
+)
+
+// operatorLabels select the Deployment of the operator in deployment.yaml
+// and its pods.
+var operatorLabels = map[string]string{"operator": "flannel"}
+
+// Uninstall removes everything the operator created, stopping the running
+// operator first so it doesn't recreate it. It stops at the first error and
+// can be run again.
+func (c *Operator) Uninstall() error {
+	log.Notice("Uninstalling flannel operator")
+
+	if err := c.stopOperatorDeployments(); err != nil {
+		return fmt.Errorf("stop operator: %s", err)
+	}
+
+	list, err := c.fclient.FlannelNetworks(api.NamespaceAll).List(api.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("list FlannelNetworks: %s", err)
+	}
+	networks := list.Items
+	clusterList, err := c.fclient.ClusterFlannelNetworks().List(api.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("list ClusterFlannelNetworks: %s", err)
+	}
+	for _, flan := range clusterList.Items {
+		networks = append(networks, flan.AsFlannelNetwork())
+	}
+
+	// The installers remove the conflist and the flannel-select plugin
+	// from the nodes once the conflist is gone from the ConfigMap.
+	cm := &v1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      cniConfigMapName,
+			Namespace: kubeSystemNamespace,
+			Labels: map[string]string{
+				"app": cniInstallerName,
+			},
+		},
+	}
+	if err := c.createOrUpdateConfigMap(cm); err != nil {
+		return fmt.Errorf("clear CNI ConfigMap: %s", err)
+	}
+	if c.plan == nil {
+		log.Notice("Waiting", cniCleanupWait, "for the CNI configs to be removed from the nodes")
+		time.Sleep(cniCleanupWait)
+	}
+
+	deplClient := c.kclient.Extensions().Deployments(kubeSystemNamespace)
+	for _, flan := range networks {
+		if err := c.deleteIfExists("Deployment", kubeSystemNamespace, clientDeploymentName(flan), deplClient.Delete); err != nil {
+			return fmt.Errorf("delete flannel client of %s: %s", networkKey(flan), err)
+		}
+	}
+	for _, flan := range networks {
+		if err := c.netStore.DeleteNetworkConfig(flan); err != nil {
+			return fmt.Errorf("delete network config of %s: %s", networkKey(flan), err)
+		}
+	}
+
+	dsetClient := c.kclient.Extensions().DaemonSets(kubeSystemNamespace)
+	for _, name := range []string{dsetFlannelName, cniInstallerName} {
+		if err := c.deleteIfExists("DaemonSet", kubeSystemNamespace, name, dsetClient.Delete); err != nil {
+			return fmt.Errorf("delete daemonset %s: %s", name, err)
+		}
+	}
+
+	cmClient := c.kclient.Core().ConfigMaps(kubeSystemNamespace)
+	for _, name := range []string{cniConfigMapName, policyConfigMapName, netConfConfigMapName} {
+		if err := c.deleteIfExists("ConfigMap", kubeSystemNamespace, name, cmClient.Delete); err != nil {
+			return fmt.Errorf("delete configmap %s: %s", name, err)
+		}
+	}
+
+	// The webhooks fail closed, so their registration has to go before
+	// their certificate, or creates of networks would be rejected for good.
+	if err := c.deleteWebhookConfigurations(); err != nil {
+		return err
+	}
+
+	// The etcd TLS Secret is provided by the admin and stays.
+	secretClient := c.kclient.Core().Secrets(kubeSystemNamespace)
+	for _, name := range []string{caSecretName, serverSecretName, clientSecretName, webhookSecretName} {
+		if err := c.deleteIfExists("Secret", kubeSystemNamespace, name, secretClient.Delete); err != nil {
+			return fmt.Errorf("delete secret %s: %s", name, err)
+		}
+	}
+
+	for _, flan := range networks {
+		if c.dryRun(planDelete, networkKind(flan), flan.Namespace, flan.Name, nil, nil) {
+			continue
+		}
+		if err := c.deleteNetwork(flan); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("delete %s %s: %s", networkKind(flan), networkKey(flan), err)
+		}
+	}
+	if err := c.deleteCRDs(); err != nil {
+		return err
+	}
+
+	log.Notice("Flannel operator uninstalled")
+	return nil
+}
+
+// stopOperatorDeployments scales the Deployments of the operator down to
+// zero and waits for their pods to go away. Uninstall has to run outside of
+// them.
+func (c *Operator) stopOperatorDeployments() error {
+	selector := labels.SelectorFromSet(operatorLabels)
+	deplClient := c.kclient.Extensions().Deployments(api.NamespaceAll)
+
+	depls, err := deplClient.List(api.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return fmt.Errorf("list deployments: %s", err)
+	}
+	for i := range depls.Items {
+		depl := &depls.Items[i]
+		if depl.Spec.Replicas != nil && *depl.Spec.Replicas == 0 {
+			continue
+		}
+		var replicas int32
+		if c.dryRun(planUpdate, "Deployment", depl.Namespace, depl.Name, depl.Spec.Replicas, &replicas) {
+			continue
+		}
+		depl.Spec.Replicas = &replicas
+		if _, err := c.kclient.Extensions().Deployments(depl.Namespace).Update(depl); err != nil {
+			return fmt.Errorf("scale down deployment %s/%s: %s", depl.Namespace, depl.Name, err)
+		}
+		log.Notice("Scaled down Deployment", depl.Namespace+"/"+depl.Name)
+	}
+	if c.plan != nil {
+		return nil
+	}
+
+	timeout := time.After(operatorStopTimeout)
+	for {
+		pods, err := c.kclient.Core().Pods(api.NamespaceAll).List(api.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return fmt.Errorf("list operator pods: %s", err)
+		}
+		if len(pods.Items) == 0 {
+			return nil
+		}
+		log.Notice("Waiting for", len(pods.Items), "operator pods to stop")
+
+		select {
+		case <-timeout:
+			return fmt.Errorf("operator pods still running after %s", operatorStopTimeout)
+		case <-time.After(operatorPollInterval):
+		}
+	}
+}
+
+// deleteIfExists deletes the object and its dependents through del. Objects
+// that are already gone are fine.
+func (c *Operator) deleteIfExists(kind, namespace, name string, del func(string, *api.DeleteOptions) error) error {
+	if c.dryRun(planDelete, kind, namespace, name, nil, nil) {
+		return nil
+	}
+
+	orphan := false
+	err := del(name, &api.DeleteOptions{OrphanDependents: &orphan})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err == nil {
+		log.Notice("Deleted", kind, namespace+"/"+name)
+	}
+	return err
+}
@@ -0,0 +1,263 @@
+// Copyright 2017 Steffen Gebert
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flannel
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1alpha1"
+
+	"k8s.io/client-go/1.5/pkg/api"
+	"k8s.io/client-go/1.5/pkg/api/errors"
+	"k8s.io/client-go/1.5/pkg/api/v1"
+	"k8s.io/client-go/1.5/pkg/fields"
+	"k8s.io/client-go/1.5/pkg/labels"
+)
+
+const (
+	defaultFlannelVersion = "v0.6.2"
+
+	templateHashLabel = "template-hash"
+	// failedRolloutAnnotation on the DaemonSet remembers the template hash
+	// of a rollout that was rolled back, so it isn't retried on every
+	// start of the operator.
+	failedRolloutAnnotation = v1alpha1.TPRGroup + "/failed-template-hash"
+
+	// rolloutNodeTimeout is how long a node gets to report the new
+	// flannel-server pod as ready before the rollout is rolled back.
+	rolloutNodeTimeout  = 5 * time.Minute
+	rolloutPollInterval = 5 * time.Second
+)
+
+// errOperatorStopped interrupts a rollout when the operator stops. The
+// rollout is neither rolled back nor marked as failed then, the next start
+// resumes it.
+var errOperatorStopped = fmt.Errorf("operator stopped")
+
+// templateHash identifies a pod template.
+func templateHash(template v1.PodTemplateSpec) string {
+	return objectHash(template)
+}
+
+func isPodReady(pod *v1.Pod) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == v1.PodReady {
+			return cond.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+type podsByNode []v1.Pod
+
+func (p podsByNode) Len() int           { return len(p) }
+func (p podsByNode) Less(i, j int) bool { return p[i].Spec.NodeName < p[j].Spec.NodeName }
+func (p podsByNode) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// rolloutServerPeriodically runs rolloutServer on start, on triggerRollout
+// and every resyncPeriod until stopc is closed, so pods that were recreated
+// with an old template or left behind by an interrupted rollout get
+// replaced.
+func (c *Operator) rolloutServerPeriodically(stopc <-chan struct{}) {
+	ticker := time.NewTicker(resyncPeriod)
+	defer ticker.Stop()
+
+	for {
+		if !c.beginReconcile() {
+			return
+		}
+		err := c.rolloutServer(stopc)
+		c.inflight.Done()
+		if err == errOperatorStopped {
+			log.Notice("Rollout of flannel-server interrupted, resuming on the next start")
+			return
+		}
+		if err != nil {
+			log.Error("Rolling out flannel-server failed:", err)
+		}
+
+		select {
+		case <-stopc:
+			return
+		case <-ticker.C:
+		case <-c.rolloutc:
+		}
+	}
+}
+
+// triggerRollout has rolloutServerPeriodically run rolloutServer right
+// away, e.g. after the server certificate was renewed.
+func (c *Operator) triggerRollout() {
+	select {
+	case c.rolloutc <- struct{}{}:
+	default:
+		// A rollout is pending already.
+	}
+}
+
+// rolloutServer brings the flannel-server DaemonSet to the desired template,
+// e.g. a new flannel version. The DaemonSet doesn't replace its pods on
+// updates, so they are replaced here one node at a time, waiting for the
+// new pod to become ready before moving on. If a node doesn't get ready in
+// time, the previous template is restored on all nodes.
+func (c *Operator) rolloutServer(stopc <-chan struct{}) error {
+	dsetClient := c.kclient.Extensions().DaemonSets(kubeSystemNamespace)
+
+	desired, err := c.newServerDaemonSet()
+	if err != nil {
+		return err
+	}
+	hash := desired.Spec.Template.Labels[templateHashLabel]
+	version := desired.Labels["version"]
+
+	current, err := dsetClient.Get(dsetFlannelName)
+	if err != nil {
+		return fmt.Errorf("get daemonset: %s", err)
+	}
+	if current.Annotations[failedRolloutAnnotation] == hash {
+		log.Warning("Rollout of flannel-server", version, "failed before, not retrying. Change the config to try again.")
+		return nil
+	}
+	previous := current.Spec.Template
+
+	if previous.Labels[templateHashLabel] != hash {
+		log.Notice("Rolling out flannel-server", version, "(was", current.Labels["version"], ")")
+		if err := c.createOrUpdateDaemonSet(desired); err != nil {
+			return fmt.Errorf("update daemonset: %s", err)
+		}
+	}
+
+	pods, err := c.serverPods(fields.Everything())
+	if err != nil {
+		return err
+	}
+	sort.Sort(podsByNode(pods))
+
+	replaced := 0
+	for _, pod := range pods {
+		if pod.Labels[templateHashLabel] == hash {
+			continue
+		}
+		replaced++
+
+		node := pod.Spec.NodeName
+		log.Notice("Replacing flannel-server on node", node)
+		if c.dryRun(planDelete, "Pod", pod.Namespace, pod.Name, nil, nil) {
+			// There's no new pod to wait for.
+			continue
+		}
+		if err := c.kclient.Core().Pods(kubeSystemNamespace).Delete(pod.Name, nil); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("delete pod %s: %s", pod.Name, err)
+		}
+
+		if err := c.waitForServerPod(node, hash, stopc); err != nil {
+			if err == errOperatorStopped {
+				return err
+			}
+			log.Error("flannel-server on node", node, "did not get ready:", err)
+			if previous.Labels[templateHashLabel] == hash {
+				// Resumed rollout, there is nothing to go back to.
+				return fmt.Errorf("rollout of %s paused: %s", version, err)
+			}
+			if rbErr := c.rollbackServer(previous, hash); rbErr != nil {
+				return fmt.Errorf("rollback after failed rollout: %s", rbErr)
+			}
+			return fmt.Errorf("rollout of %s paused and rolled back: %s", version, err)
+		}
+	}
+
+	if replaced > 0 {
+		log.Notice("flannel-server", version, "rolled out on all nodes")
+	}
+	return nil
+}
+
+// serverPods lists the flannel-server pods matching the field selector.
+func (c *Operator) serverPods(fieldSelector fields.Selector) ([]v1.Pod, error) {
+	pods, err := c.kclient.Core().Pods(kubeSystemNamespace).List(api.ListOptions{
+		LabelSelector: labels.SelectorFromSet(map[string]string{"app": dsetFlannelName}),
+		FieldSelector: fieldSelector,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list flannel-server pods: %s", err)
+	}
+	return pods.Items, nil
+}
+
+// waitForServerPod waits until the flannel-server pod with the given
+// template hash on the node is ready.
+func (c *Operator) waitForServerPod(node, hash string, stopc <-chan struct{}) error {
+	timeout := time.After(rolloutNodeTimeout)
+	for {
+		pods, err := c.serverPods(fields.OneTermEqualSelector("spec.nodeName", node))
+		if err != nil {
+			return err
+		}
+		for i := range pods {
+			if pods[i].Labels[templateHashLabel] == hash && isPodReady(&pods[i]) {
+				return nil
+			}
+		}
+
+		select {
+		case <-stopc:
+			return errOperatorStopped
+		case <-timeout:
+			return fmt.Errorf("timed out after %s", rolloutNodeTimeout)
+		case <-time.After(rolloutPollInterval):
+		}
+	}
+}
+
+// rollbackServer restores the previous pod template of the flannel-server
+// and replaces the pods already running the failed one.
+func (c *Operator) rollbackServer(previous v1.PodTemplateSpec, failedHash string) error {
+	dsetClient := c.kclient.Extensions().DaemonSets(kubeSystemNamespace)
+
+	current, err := dsetClient.Get(dsetFlannelName)
+	if err != nil {
+		return err
+	}
+	if current.Annotations == nil {
+		current.Annotations = map[string]string{}
+	}
+	current.Annotations[failedRolloutAnnotation] = failedHash
+	current.Labels["version"] = previous.Labels["version"]
+	if c.dryRun(planUpdate, "DaemonSet", current.Namespace, current.Name, current.Spec.Template, previous) {
+		return nil
+	}
+	current.Spec.Template = previous
+	if _, err := dsetClient.Update(current); err != nil {
+		return fmt.Errorf("restore daemonset: %s", err)
+	}
+
+	pods, err := c.serverPods(fields.Everything())
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods {
+		if pod.Labels[templateHashLabel] != failedHash {
+			continue
+		}
+		if err := c.kclient.Core().Pods(kubeSystemNamespace).Delete(pod.Name, nil); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("delete pod %s: %s", pod.Name, err)
+		}
+	}
+
+	log.Warning("Rolled back flannel-server to version", previous.Labels["version"])
+	return nil
+}
@@ -0,0 +1,237 @@
+// Copyright 2017 Steffen Gebert
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flannel
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1alpha1"
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1beta1"
+)
+
+const (
+	// minMTU is the smallest MTU every IPv4 host has to accept.
+	minMTU = 576
+	maxMTU = 9000
+
+	// VXLAN network identifiers have 24 bits, 0 is not used by flannel.
+	minVNI = 1
+	maxVNI = 1<<24 - 1
+)
+
+// etcdPrefixRe limits etcd prefixes to plain key paths, as the operator
+// writes the network configs below them and passes them to flanneld.
+var etcdPrefixRe = regexp.MustCompile(`^(/[A-Za-z0-9._-]+)+$`)
+
+// flannelVersionRe matches flannel release tags. Versions end up in image
+// names and labels.
+var flannelVersionRe = regexp.MustCompile(`^v[0-9]+\.[0-9]+\.[0-9]+(-[0-9A-Za-z.]+)?$`)
+
+// validateConfig checks the operator config on startup.
+func validateConfig(conf Config) error {
+	if err := validateMTU(conf.MTU); err != nil {
+		return err
+	}
+	if !flannelVersionRe.MatchString(conf.FlannelVersion) {
+		return fmt.Errorf("invalid flannel version %q", conf.FlannelVersion)
+	}
+
+	if conf.Datastore != DatastoreEtcd && conf.Datastore != DatastoreKubernetes {
+		return fmt.Errorf("unknown datastore %q", conf.Datastore)
+	}
+
+	for _, e := range conf.EtcdEndpoints {
+		u, err := url.Parse(e)
+		if err != nil {
+			return fmt.Errorf("invalid etcd endpoint %q: %s", e, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+			return fmt.Errorf("invalid etcd endpoint %q: must be http(s)://<host>:<port>", e)
+		}
+		if conf.EtcdTLSSecret != "" && u.Scheme != "https" {
+			return fmt.Errorf("etcd endpoint %q is not https, but etcd TLS is configured", e)
+		}
+	}
+	if conf.EtcdTLSSecret != "" && len(conf.EtcdEndpoints) == 0 {
+		return fmt.Errorf("etcd TLS requires etcd endpoints to be given")
+	}
+
+	if conf.Datastore == DatastoreKubernetes && compareVersions(conf.FlannelVersion, kubeMinVersion) < 0 {
+		return fmt.Errorf("the %s datastore requires -flannel-version %s or later", DatastoreKubernetes, kubeMinVersion)
+	}
+	if conf.Datastore == DatastoreEtcd && compareVersions(conf.FlannelVersion, remoteMaxVersion) >= 0 {
+		return fmt.Errorf("the %s datastore requires -flannel-version before %s, later releases have no flannel-server", DatastoreEtcd, remoteMaxVersion)
+	}
+
+	if conf.RemoteTLS && conf.Datastore != DatastoreEtcd {
+		return fmt.Errorf("remote TLS requires the %s datastore", DatastoreEtcd)
+	}
+
+	if conf.EtcdPrefix != "" && !etcdPrefixRe.MatchString(conf.EtcdPrefix) {
+		return fmt.Errorf("invalid etcd prefix %q", conf.EtcdPrefix)
+	}
+
+	if err := validateResources(conf.ServerResources); err != nil {
+		return fmt.Errorf("invalid flannel-server resources: %s", err)
+	}
+	if err := validateResources(conf.ClientResources); err != nil {
+		return fmt.Errorf("invalid flannel client resources: %s", err)
+	}
+	if err := validatePodTemplateOverrides(conf.PodTemplateOverrides); err != nil {
+		return fmt.Errorf("invalid pod template overrides: %s", err)
+	}
+
+	return nil
+}
+
+// validateFlannelNetwork checks the spec of a FlannelNetwork before anything
+// is deployed for it with the given datastore.
+func validateFlannelNetwork(flan *v1alpha1.FlannelNetwork, datastore string) error {
+	vni, err := strconv.Atoi(flan.Spec.VNI)
+	if err != nil || vni < minVNI || vni > maxVNI {
+		return fmt.Errorf("invalid VNI %q: must be a number in [%d, %d]", flan.Spec.VNI, minVNI, maxVNI)
+	}
+	ip, _, err := net.ParseCIDR(flan.Spec.Cidr)
+	if err != nil {
+		return fmt.Errorf("invalid CIDR %q: %s", flan.Spec.Cidr, err)
+	}
+	if ip.To4() == nil {
+		return fmt.Errorf("invalid CIDR %q: flannel only supports IPv4", flan.Spec.Cidr)
+	}
+
+	if _, err := v1beta1.PlacementFromAnnotations(flan.Annotations); err != nil {
+		return err
+	}
+
+	if flan.Spec.Backend != "" && flan.Spec.Backend != backendVXLAN {
+		return fmt.Errorf("unsupported backend %q, only %s is supported", flan.Spec.Backend, backendVXLAN)
+	}
+
+	if flan.Spec.MTU != 0 {
+		if err := validateMTU(flan.Spec.MTU); err != nil {
+			return err
+		}
+	}
+
+	if flan.Spec.FlannelVersion != "" && !flannelVersionRe.MatchString(flan.Spec.FlannelVersion) {
+		return fmt.Errorf("invalid flannel version %q", flan.Spec.FlannelVersion)
+	}
+	if datastore == DatastoreEtcd && flan.Spec.FlannelVersion != "" && compareVersions(flan.Spec.FlannelVersion, remoteMaxVersion) >= 0 {
+		return fmt.Errorf("flannel version %s has no client mode, the %s datastore requires a release before %s", flan.Spec.FlannelVersion, DatastoreEtcd, remoteMaxVersion)
+	}
+
+	if len(flan.Spec.MasqueradeExceptions) > 0 && flan.Spec.IPMasq != nil && !*flan.Spec.IPMasq {
+		return fmt.Errorf("masqueradeExceptions given, but ipMasq is disabled")
+	}
+	for _, e := range flan.Spec.MasqueradeExceptions {
+		if _, _, err := net.ParseCIDR(e); err != nil {
+			return fmt.Errorf("invalid masquerade exception %q: %s", e, err)
+		}
+	}
+
+	if flan.Spec.Resources != nil {
+		if err := validateResources(*flan.Spec.Resources); err != nil {
+			return fmt.Errorf("invalid resources: %s", err)
+		}
+	}
+	if err := validatePodTemplateOverrides(flan.Spec.PodTemplateOverrides); err != nil {
+		return fmt.Errorf("invalid podTemplateOverrides: %s", err)
+	}
+
+	return nil
+}
+
+// validateNetworkConflicts checks that the VNI and CIDR of the FlannelNetwork
+// aren't used by any of the others. The network itself may be among them.
+func validateNetworkConflicts(flan *v1alpha1.FlannelNetwork, others []*v1alpha1.FlannelNetwork) error {
+	vni, err := strconv.Atoi(flan.Spec.VNI)
+	if err != nil {
+		return fmt.Errorf("invalid VNI %q: %s", flan.Spec.VNI, err)
+	}
+	_, cidr, err := net.ParseCIDR(flan.Spec.Cidr)
+	if err != nil {
+		return fmt.Errorf("invalid CIDR %q: %s", flan.Spec.Cidr, err)
+	}
+
+	for _, other := range others {
+		if networkKey(other) == networkKey(flan) {
+			continue
+		}
+		// VNIs are compared by value, "01" and "1" are the same to
+		// flannel.
+		if otherVNI, err := strconv.Atoi(other.Spec.VNI); err == nil && otherVNI == vni {
+			return fmt.Errorf("VNI %s is already used by FlannelNetwork %s", flan.Spec.VNI, networkKey(other))
+		}
+		_, otherCidr, err := net.ParseCIDR(other.Spec.Cidr)
+		if err != nil {
+			continue
+		}
+		if cidr.Contains(otherCidr.IP) || otherCidr.Contains(cidr.IP) {
+			return fmt.Errorf("CIDR %s overlaps with %s of FlannelNetwork %s", flan.Spec.Cidr, other.Spec.Cidr, networkKey(other))
+		}
+	}
+	return nil
+}
+
+// checkNetworkConflicts checks the VNI and CIDR of the network against the
+// networks created before it, so of two conflicting networks the older one
+// is kept. Without the admission webhook, such networks can be created.
+func (c *Operator) checkNetworkConflicts(flan *v1alpha1.FlannelNetwork) error {
+	var older []*v1alpha1.FlannelNetwork
+	for _, other := range c.networks() {
+		if createdBefore(other, flan) {
+			older = append(older, other)
+		}
+	}
+	return validateNetworkConflicts(flan, older)
+}
+
+// createdBefore tells whether a was created before b. Networks created at
+// the same time are ordered by their key, and a network without a creation
+// timestamp isn't created yet and so comes last.
+func createdBefore(a, b *v1alpha1.FlannelNetwork) bool {
+	if networkKey(a) == networkKey(b) {
+		return false
+	}
+	if b.CreationTimestamp.IsZero() {
+		return true
+	}
+	ta, tb := a.CreationTimestamp.Time, b.CreationTimestamp.Time
+	return ta.Before(tb) || (ta.Equal(tb) && networkKey(a) < networkKey(b))
+}
+
+// validateFlannelNetworkUpdate rejects changes to the fields that identify
+// the network on the nodes. Changing them would leave the leases, bridges
+// and routes of the old network behind.
+func validateFlannelNetworkUpdate(old, cur *v1alpha1.FlannelNetwork) error {
+	if cur.Spec.VNI != old.Spec.VNI {
+		return fmt.Errorf("spec.vni is immutable")
+	}
+	if cur.Spec.Cidr != old.Spec.Cidr {
+		return fmt.Errorf("spec.cidr is immutable")
+	}
+	return nil
+}
+
+func validateMTU(mtu int) error {
+	if mtu < minMTU || mtu > maxMTU {
+		return fmt.Errorf("MTU %d out of range [%d, %d]", mtu, minMTU, maxMTU)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2017 Steffen Gebert
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flannel
+
+import (
+	"testing"
+
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1alpha1"
+)
+
+func TestValidateNetworkConflicts(t *testing.T) {
+	others := []*v1alpha1.FlannelNetwork{
+		vniNetwork("tenant-a", "web", "1", "10.1.0.0/16"),
+		vniNetwork("", "shared", "7", "10.7.0.0/16"),
+	}
+
+	tests := []struct {
+		name    string
+		flan    *v1alpha1.FlannelNetwork
+		wantErr bool
+	}{
+		{"distinct", vniNetwork("tenant-b", "web", "2", "10.2.0.0/16"), false},
+		{"same VNI", vniNetwork("tenant-b", "web", "1", "10.2.0.0/16"), true},
+		{"same VNI with leading zero", vniNetwork("tenant-b", "web", "01", "10.2.0.0/16"), true},
+		{"same VNI with sign", vniNetwork("tenant-b", "web", "+1", "10.2.0.0/16"), true},
+		{"VNI of a cluster network", vniNetwork("tenant-b", "web", "007", "10.2.0.0/16"), true},
+		{"overlapping CIDR", vniNetwork("tenant-b", "web", "2", "10.1.128.0/17"), true},
+		{"enclosing CIDR", vniNetwork("tenant-b", "web", "2", "10.0.0.0/8"), true},
+		{"itself", vniNetwork("tenant-a", "web", "1", "10.1.0.0/16"), false},
+		{"invalid VNI", vniNetwork("tenant-b", "web", "one", "10.2.0.0/16"), true},
+	}
+	for _, tt := range tests {
+		err := validateNetworkConflicts(tt.flan, others)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateNetworkConflicts() = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateFlannelVersions(t *testing.T) {
+	tests := []struct {
+		datastore string
+		version   string
+		wantErr   bool
+	}{
+		{DatastoreEtcd, "v0.6.2", false},
+		{DatastoreEtcd, "v0.7.1", false},
+		// Client/server mode is gone from v0.8.0 on.
+		{DatastoreEtcd, "v0.8.0", true},
+		{DatastoreEtcd, "v0.10.0", true},
+		{DatastoreKubernetes, "v0.9.1", true},
+		{DatastoreKubernetes, "v0.10.0", false},
+	}
+	for _, tt := range tests {
+		err := validateConfig(Config{MTU: defaultMTU, Datastore: tt.datastore, FlannelVersion: tt.version})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateConfig(%s, %s) = %v, want error %v", tt.datastore, tt.version, err, tt.wantErr)
+		}
+	}
+
+	for _, tt := range tests {
+		flan := vniNetwork("tenant-a", "web", "1", "10.1.0.0/16")
+		flan.Spec.FlannelVersion = tt.version
+		// The minimum of the Kubernetes datastore is checked by
+		// checkKubernetesDatastore, along with the operator's version.
+		wantErr := tt.wantErr && tt.datastore == DatastoreEtcd
+		err := validateFlannelNetwork(flan, tt.datastore)
+		if (err != nil) != wantErr {
+			t.Errorf("validateFlannelNetwork(%s, %s) = %v, want error %v", tt.datastore, tt.version, err, wantErr)
+		}
+	}
+}
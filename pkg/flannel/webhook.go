@@ -0,0 +1,497 @@
+// Copyright 2017 Steffen Gebert
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flannel
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1alpha1"
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1beta1"
+)
+
+const (
+	webhookSecretName = "flannel-webhook-tls"
+	// webhookConfigName names the Validating- and
+	// MutatingWebhookConfiguration the webhooks are registered with.
+	webhookConfigName = "flannel-operator"
+
+	validatingWebhookPath = "/apis/admissionregistration.k8s.io/v1beta1/validatingwebhookconfigurations"
+	mutatingWebhookPath   = "/apis/admissionregistration.k8s.io/v1beta1/mutatingwebhookconfigurations"
+
+	admissionCreate = "CREATE"
+	admissionUpdate = "UPDATE"
+)
+
+// admissionReview is the admission.k8s.io/v1beta1 AdmissionReview sent to
+// webhooks, reduced to the fields used here.
+type admissionReview struct {
+	APIVersion string             `json:"apiVersion"`
+	Kind       string             `json:"kind"`
+	Request    *admissionRequest  `json:"request,omitempty"`
+	Response   *admissionResponse `json:"response,omitempty"`
+}
+
+type admissionRequest struct {
+	UID       string          `json:"uid"`
+	Kind      admissionKind   `json:"kind"`
+	Operation string          `json:"operation"`
+	Namespace string          `json:"namespace"`
+	Object    json.RawMessage `json:"object"`
+	OldObject json.RawMessage `json:"oldObject"`
+}
+
+type admissionKind struct {
+	Group   string `json:"group"`
+	Version string `json:"version"`
+	Kind    string `json:"kind"`
+}
+
+type admissionResponse struct {
+	UID       string           `json:"uid"`
+	Allowed   bool             `json:"allowed"`
+	Result    *admissionStatus `json:"status,omitempty"`
+	Patch     []byte           `json:"patch,omitempty"`
+	PatchType string           `json:"patchType,omitempty"`
+}
+
+type admissionStatus struct {
+	Message string `json:"message"`
+}
+
+// conversionReview is the apiextensions.k8s.io/v1beta1 ConversionReview sent
+// to conversion webhooks.
+type conversionReview struct {
+	APIVersion string              `json:"apiVersion"`
+	Kind       string              `json:"kind"`
+	Request    *conversionRequest  `json:"request,omitempty"`
+	Response   *conversionResponse `json:"response,omitempty"`
+}
+
+type conversionRequest struct {
+	UID               string            `json:"uid"`
+	DesiredAPIVersion string            `json:"desiredAPIVersion"`
+	Objects           []json.RawMessage `json:"objects"`
+}
+
+type conversionResponse struct {
+	UID              string            `json:"uid"`
+	ConvertedObjects []json.RawMessage `json:"convertedObjects"`
+	Result           conversionResult  `json:"result"`
+}
+
+type conversionResult struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// webhookConfiguration is the admissionregistration.k8s.io/v1beta1
+// ValidatingWebhookConfiguration or MutatingWebhookConfiguration, reduced to
+// the fields used here.
+type webhookConfiguration struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   objectMeta `json:"metadata"`
+	Webhooks   []webhook  `json:"webhooks"`
+}
+
+type webhook struct {
+	Name          string              `json:"name"`
+	ClientConfig  webhookClientConfig `json:"clientConfig"`
+	Rules         []webhookRule       `json:"rules"`
+	FailurePolicy string              `json:"failurePolicy"`
+	// MatchPolicy Equivalent has the apiserver send v1beta1 requests
+	// converted to v1alpha1, the only version the webhooks decode. It is
+	// left out before conversionKubeMinor, where v1beta1 isn't served.
+	MatchPolicy string `json:"matchPolicy,omitempty"`
+}
+
+type webhookClientConfig struct {
+	Service  *serviceReference `json:"service"`
+	CABundle []byte            `json:"caBundle"`
+}
+
+type serviceReference struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+}
+
+type webhookRule struct {
+	APIGroups   []string `json:"apiGroups"`
+	APIVersions []string `json:"apiVersions"`
+	Resources   []string `json:"resources"`
+	Operations  []string `json:"operations"`
+}
+
+// webhookClientConfigFor points the apiserver at path of the webhook
+// Service, trusting the operator's CA.
+func (c *Operator) webhookClientConfigFor(path string, caBundle []byte) webhookClientConfig {
+	return webhookClientConfig{
+		Service: &serviceReference{
+			Namespace: c.config.WebhookNamespace,
+			Name:      c.config.WebhookService,
+			Path:      path,
+		},
+		CABundle: caBundle,
+	}
+}
+
+// webhookConfiguration registers the webhook on path for the given
+// operations on networks of both kinds.
+func (c *Operator) webhookConfiguration(kind, name, path string, operations []string, caBundle []byte) webhookConfiguration {
+	matchPolicy := ""
+	if c.kubeMinor >= conversionKubeMinor {
+		matchPolicy = "Equivalent"
+	}
+	return webhookConfiguration{
+		APIVersion: "admissionregistration.k8s.io/v1beta1",
+		Kind:       kind,
+		Metadata:   objectMeta{Name: webhookConfigName},
+		Webhooks: []webhook{
+			{
+				Name:         name,
+				ClientConfig: c.webhookClientConfigFor(path, caBundle),
+				Rules: []webhookRule{
+					{
+						APIGroups:   []string{v1alpha1.TPRGroup},
+						APIVersions: []string{v1alpha1.TPRVersion},
+						Resources:   []string{v1alpha1.TPRFlannelName, v1alpha1.TPRClusterFlannelName},
+						Operations:  operations,
+					},
+				},
+				FailurePolicy: "Fail",
+				MatchPolicy:   matchPolicy,
+			},
+		},
+	}
+}
+
+// syncWebhookConfigurations registers the webhooks with the apiserver, or
+// updates their registration, e.g. with the caBundle of a renewed CA.
+func (c *Operator) syncWebhookConfigurations(caBundle []byte) error {
+	configs := []struct {
+		path   string
+		config webhookConfiguration
+	}{
+		{
+			path:   validatingWebhookPath,
+			config: c.webhookConfiguration("ValidatingWebhookConfiguration", "validate."+v1alpha1.TPRGroup, "/validate", []string{admissionCreate, admissionUpdate}, caBundle),
+		},
+		{
+			path:   mutatingWebhookPath,
+			config: c.webhookConfiguration("MutatingWebhookConfiguration", "mutate."+v1alpha1.TPRGroup, "/mutate", []string{admissionCreate}, caBundle),
+		},
+	}
+	for _, wc := range configs {
+		patch := map[string]interface{}{"webhooks": wc.config.Webhooks}
+		if err := c.createOrPatchRaw(wc.config.Kind, wc.path, webhookConfigName, wc.config, patch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteWebhookConfigurations removes the registration of the webhooks.
+func (c *Operator) deleteWebhookConfigurations() error {
+	if err := c.deleteRaw("ValidatingWebhookConfiguration", validatingWebhookPath, webhookConfigName); err != nil {
+		return err
+	}
+	return c.deleteRaw("MutatingWebhookConfiguration", mutatingWebhookPath, webhookConfigName)
+}
+
+// webhookDNSNames are the names the apiserver may reach the webhook Service
+// by.
+func (c *Operator) webhookDNSNames() []string {
+	svc, ns := c.config.WebhookService, c.config.WebhookNamespace
+	return []string{
+		svc,
+		svc + "." + ns,
+		svc + "." + ns + ".svc",
+		svc + "." + ns + ".svc.cluster.local",
+	}
+}
+
+// syncWebhookCertificate issues the serving certificate of the webhook with
+// the operator's CA and loads it into webhookCert.
+func (c *Operator) syncWebhookCertificate() error {
+	c.certMtx.Lock()
+	defer c.certMtx.Unlock()
+
+	ca, _, err := c.ensureCA()
+	if err != nil {
+		return err
+	}
+	return c.issueWebhookCertificate(ca)
+}
+
+// issueWebhookCertificate does the work of syncWebhookCertificate with the
+// given CA and registers the webhooks with it. c.certMtx must be held.
+func (c *Operator) issueWebhookCertificate(ca *keyPair) error {
+	if _, err := c.ensureCertificate(ca, webhookSecretName, c.config.WebhookService, c.webhookDNSNames(), x509.ExtKeyUsageServerAuth); err != nil {
+		return err
+	}
+
+	secret, err := c.kclient.Core().Secrets(kubeSystemNamespace).Get(webhookSecretName)
+	if err != nil {
+		return fmt.Errorf("get webhook certificate: %s", err)
+	}
+	cert, err := tls.X509KeyPair(secret.Data["tls.crt"], secret.Data["tls.key"])
+	if err != nil {
+		return fmt.Errorf("load webhook certificate: %s", err)
+	}
+	c.webhookCert.Store(&cert)
+
+	// The apiserver trusts the webhooks by the caBundle, which has to
+	// follow every renewal of the CA.
+	if err := c.syncWebhookConfigurations(ca.certPEM()); err != nil {
+		return fmt.Errorf("register webhooks: %s", err)
+	}
+	if err := c.createCRDs(ca.certPEM()); err != nil {
+		return fmt.Errorf("register conversion webhook: %s", err)
+	}
+	return nil
+}
+
+// runWebhook serves the admission webhooks over TLS until stopc is closed,
+// renewing the serving certificate along with the other certificates.
+func (c *Operator) runWebhook(stopc <-chan struct{}) {
+	if c.plan != nil {
+		log.Warning("Not serving the admission webhook in dry-run mode, it has no certificate")
+		return
+	}
+	for {
+		err := c.syncWebhookCertificate()
+		if err == nil {
+			break
+		}
+		log.Error("Issuing webhook certificate failed:", err)
+		select {
+		case <-stopc:
+			return
+		case <-time.After(10 * time.Second):
+		}
+	}
+
+	l, err := net.Listen("tcp", c.config.WebhookListenAddress)
+	if err != nil {
+		log.Error("Listening on", c.config.WebhookListenAddress, "failed:", err)
+		return
+	}
+	tl := tls.NewListener(l, &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return c.webhookCert.Load().(*tls.Certificate), nil
+		},
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/validate", c.serveValidate)
+	mux.HandleFunc("/mutate", c.serveMutate)
+	mux.HandleFunc("/convert", serveConvert)
+	go http.Serve(tl, mux)
+	log.Notice("Serving admission webhook on", c.config.WebhookListenAddress)
+
+	ticker := time.NewTicker(certCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopc:
+			tl.Close()
+			return
+		case <-ticker.C:
+			if err := c.syncWebhookCertificate(); err != nil {
+				log.Error("Renewing webhook certificate failed:", err)
+			}
+		}
+	}
+}
+
+// serveAdmission decodes an AdmissionReview, lets review decide on the
+// request and writes the response.
+func (c *Operator) serveAdmission(w http.ResponseWriter, r *http.Request, review func(*admissionRequest) *admissionResponse) {
+	var ar admissionReview
+	if err := json.NewDecoder(r.Body).Decode(&ar); err != nil || ar.Request == nil {
+		http.Error(w, "invalid AdmissionReview", http.StatusBadRequest)
+		return
+	}
+	// Conflicts can't be checked before all networks are known.
+	if !c.networksSynced() {
+		http.Error(w, "caches not synced yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	resp := review(ar.Request)
+	resp.UID = ar.Request.UID
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(admissionReview{
+		APIVersion: ar.APIVersion,
+		Kind:       ar.Kind,
+		Response:   resp,
+	})
+}
+
+func (c *Operator) serveValidate(w http.ResponseWriter, r *http.Request) {
+	c.serveAdmission(w, r, func(req *admissionRequest) *admissionResponse {
+		if err := c.validateAdmission(req); err != nil {
+			return &admissionResponse{Result: &admissionStatus{Message: err.Error()}}
+		}
+		return &admissionResponse{Allowed: true}
+	})
+}
+
+func (c *Operator) serveMutate(w http.ResponseWriter, r *http.Request) {
+	c.serveAdmission(w, r, func(req *admissionRequest) *admissionResponse {
+		patch, err := c.mutateAdmission(req)
+		if err != nil {
+			return &admissionResponse{Result: &admissionStatus{Message: err.Error()}}
+		}
+		if patch == nil {
+			return &admissionResponse{Allowed: true}
+		}
+		return &admissionResponse{Allowed: true, Patch: patch, PatchType: "JSONPatch"}
+	})
+}
+
+// mutateAdmission returns a JSON patch applying the defaults to a created
+// FlannelNetwork or ClusterFlannelNetwork, or nil if there is nothing to
+// default.
+func (c *Operator) mutateAdmission(req *admissionRequest) ([]byte, error) {
+	if req.Operation != admissionCreate {
+		return nil, nil
+	}
+
+	var flan v1alpha1.FlannelNetwork
+	if err := json.Unmarshal(req.Object, &flan); err != nil {
+		return nil, fmt.Errorf("decode FlannelNetwork: %s", err)
+	}
+
+	defaulted, err := defaultFlannelNetwork(&flan, c.config, c.networks())
+	if err != nil {
+		return nil, err
+	}
+	return defaultsPatch(&flan, defaulted)
+}
+
+// validateAdmission checks a created or updated FlannelNetwork or
+// ClusterFlannelNetwork against its own rules and against the other
+// networks of both kinds.
+func (c *Operator) validateAdmission(req *admissionRequest) error {
+	if req.Operation != admissionCreate && req.Operation != admissionUpdate {
+		return nil
+	}
+
+	var flan v1alpha1.FlannelNetwork
+	if err := json.Unmarshal(req.Object, &flan); err != nil {
+		return fmt.Errorf("decode FlannelNetwork: %s", err)
+	}
+	// The namespace is empty for ClusterFlannelNetworks, which are
+	// cluster-scoped.
+	if flan.Namespace == "" {
+		flan.Namespace = req.Namespace
+	}
+
+	if err := validateFlannelNetwork(&flan, c.config.Datastore); err != nil {
+		return err
+	}
+
+	if req.Operation == admissionUpdate {
+		var old v1alpha1.FlannelNetwork
+		if err := json.Unmarshal(req.OldObject, &old); err != nil {
+			return fmt.Errorf("decode old FlannelNetwork: %s", err)
+		}
+		if err := validateFlannelNetworkUpdate(&old, &flan); err != nil {
+			return err
+		}
+	}
+
+	if err := c.checkKubernetesDatastore(&flan); err != nil {
+		return err
+	}
+	return validateNetworkConflicts(&flan, c.networks())
+}
+
+// serveConvert converts FlannelNetworks between v1alpha1 and v1beta1.
+func serveConvert(w http.ResponseWriter, r *http.Request) {
+	var cr conversionReview
+	if err := json.NewDecoder(r.Body).Decode(&cr); err != nil || cr.Request == nil {
+		http.Error(w, "invalid ConversionReview", http.StatusBadRequest)
+		return
+	}
+
+	resp := &conversionResponse{
+		UID:    cr.Request.UID,
+		Result: conversionResult{Status: "Success"},
+	}
+	for _, obj := range cr.Request.Objects {
+		converted, err := convertFlannelNetwork(obj, cr.Request.DesiredAPIVersion)
+		if err != nil {
+			resp.ConvertedObjects = nil
+			resp.Result = conversionResult{Status: "Failure", Message: err.Error()}
+			break
+		}
+		resp.ConvertedObjects = append(resp.ConvertedObjects, converted)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conversionReview{
+		APIVersion: cr.APIVersion,
+		Kind:       cr.Kind,
+		Response:   resp,
+	})
+}
+
+// convertFlannelNetwork converts a FlannelNetwork to the given API version.
+func convertFlannelNetwork(obj json.RawMessage, desiredAPIVersion string) (json.RawMessage, error) {
+	var meta struct {
+		APIVersion string `json:"apiVersion"`
+	}
+	if err := json.Unmarshal(obj, &meta); err != nil {
+		return nil, err
+	}
+	if meta.APIVersion == desiredAPIVersion {
+		return obj, nil
+	}
+
+	alpha := v1alpha1.TPRGroup + "/" + v1alpha1.TPRVersion
+	beta := v1beta1.TPRGroup + "/" + v1beta1.TPRVersion
+	switch {
+	case meta.APIVersion == alpha && desiredAPIVersion == beta:
+		var in v1alpha1.FlannelNetwork
+		if err := json.Unmarshal(obj, &in); err != nil {
+			return nil, err
+		}
+		out, err := v1beta1.ConvertFromV1alpha1(&in)
+		if err != nil {
+			return nil, fmt.Errorf("convert %s/%s: %s", in.Namespace, in.Name, err)
+		}
+		return json.Marshal(out)
+	case meta.APIVersion == beta && desiredAPIVersion == alpha:
+		var in v1beta1.FlannelNetwork
+		if err := json.Unmarshal(obj, &in); err != nil {
+			return nil, err
+		}
+		out, err := v1beta1.ConvertToV1alpha1(&in)
+		if err != nil {
+			return nil, fmt.Errorf("convert %s/%s: %s", in.Namespace, in.Name, err)
+		}
+		return json.Marshal(out)
+	}
+	return nil, fmt.Errorf("cannot convert from %s to %s", meta.APIVersion, desiredAPIVersion)
+}
@@ -0,0 +1,209 @@
+// Copyright 2017 Steffen Gebert
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package integration runs the operator against a kube-apiserver and etcd
+// started from local binaries, without nodes, a scheduler or a controller
+// manager. Objects are created but no pods ever run.
+package integration
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/op/go-logging"
+
+	"k8s.io/client-go/1.5/rest"
+)
+
+var (
+	log = logging.MustGetLogger("integration")
+)
+
+const (
+	// The binaries are looked up in $PATH unless these variables point to
+	// them.
+	etcdBinaryEnv      = "INTEGRATION_ETCD"
+	apiserverBinaryEnv = "INTEGRATION_KUBE_APISERVER"
+
+	startTimeout = 60 * time.Second
+	pollInterval = 500 * time.Millisecond
+)
+
+// ControlPlane is a local etcd and kube-apiserver, both listening on
+// 127.0.0.1 only.
+type ControlPlane struct {
+	// EtcdURL is the client URL of etcd. The operator can use it for the
+	// flannel network configs as well.
+	EtcdURL string
+	// APIServerURL is the insecure URL of the kube-apiserver.
+	APIServerURL string
+
+	dir       string
+	etcd      *exec.Cmd
+	apiserver *exec.Cmd
+}
+
+// StartControlPlane starts etcd and the kube-apiserver and waits until the
+// apiserver is healthy. Stop has to be called even if it fails.
+func StartControlPlane() (*ControlPlane, error) {
+	etcdBin, err := binary(etcdBinaryEnv, "etcd")
+	if err != nil {
+		return &ControlPlane{}, err
+	}
+	apiserverBin, err := binary(apiserverBinaryEnv, "kube-apiserver")
+	if err != nil {
+		return &ControlPlane{}, err
+	}
+
+	dir, err := ioutil.TempDir("", "flannel-operator-integration")
+	if err != nil {
+		return &ControlPlane{}, err
+	}
+	cp := &ControlPlane{dir: dir}
+
+	etcdPort, err := freePort()
+	if err != nil {
+		return cp, err
+	}
+	peerPort, err := freePort()
+	if err != nil {
+		return cp, err
+	}
+	apiserverPort, err := freePort()
+	if err != nil {
+		return cp, err
+	}
+	cp.EtcdURL = "http://127.0.0.1:" + strconv.Itoa(etcdPort)
+	cp.APIServerURL = "http://127.0.0.1:" + strconv.Itoa(apiserverPort)
+
+	cp.etcd = command(filepath.Join(dir, "etcd.log"), etcdBin,
+		"--data-dir="+filepath.Join(dir, "etcd"),
+		"--listen-client-urls="+cp.EtcdURL,
+		"--advertise-client-urls="+cp.EtcdURL,
+		"--listen-peer-urls=http://127.0.0.1:"+strconv.Itoa(peerPort),
+	)
+	if err := cp.etcd.Start(); err != nil {
+		return cp, fmt.Errorf("start etcd: %s", err)
+	}
+	if err := waitForURL(cp.EtcdURL + "/health"); err != nil {
+		return cp, fmt.Errorf("etcd: %s", err)
+	}
+
+	cp.apiserver = command(filepath.Join(dir, "apiserver.log"), apiserverBin,
+		"--etcd-servers="+cp.EtcdURL,
+		// The v2 API lets the suite look at the stored objects with the
+		// same client as the flannel network configs.
+		"--storage-backend=etcd2",
+		"--insecure-bind-address=127.0.0.1",
+		"--insecure-port="+strconv.Itoa(apiserverPort),
+		"--secure-port=0",
+		"--cert-dir="+filepath.Join(dir, "certs"),
+		"--service-cluster-ip-range=10.0.0.0/24",
+		"--admission-control=AlwaysAdmit",
+	)
+	if err := cp.apiserver.Start(); err != nil {
+		return cp, fmt.Errorf("start kube-apiserver: %s", err)
+	}
+	if err := waitForURL(cp.APIServerURL + "/healthz"); err != nil {
+		return cp, fmt.Errorf("kube-apiserver: %s", err)
+	}
+
+	log.Notice("Control plane running, logs in", dir)
+	return cp, nil
+}
+
+// RESTConfig returns the client config for the kube-apiserver.
+func (cp *ControlPlane) RESTConfig() *rest.Config {
+	return &rest.Config{Host: cp.APIServerURL}
+}
+
+// Stop kills the kube-apiserver and etcd. The logs and data are kept if
+// keepDir is true, e.g. to look into a failure.
+func (cp *ControlPlane) Stop(keepDir bool) {
+	for _, cmd := range []*exec.Cmd{cp.apiserver, cp.etcd} {
+		if cmd != nil && cmd.Process != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+		}
+	}
+	if cp.dir != "" && !keepDir {
+		os.RemoveAll(cp.dir)
+	}
+}
+
+func binary(env, name string) (string, error) {
+	if path := os.Getenv(env); path != "" {
+		return path, nil
+	}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", fmt.Errorf("%s not found, put it in $PATH or set $%s", name, env)
+	}
+	return path, nil
+}
+
+// command returns the command with its output going to logFile.
+func command(logFile, name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(name, args...)
+	if f, err := os.Create(logFile); err == nil {
+		cmd.Stdout = f
+		cmd.Stderr = f
+	}
+	return cmd
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+func waitForURL(url string) error {
+	return poll(startTimeout, func() (bool, error) {
+		resp, err := http.Get(url)
+		if err != nil {
+			return false, nil
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK, nil
+	})
+}
+
+// poll calls cond until it returns true or an error, or timeout passes.
+func poll(timeout time.Duration, cond func() (bool, error)) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := cond()
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %s", timeout)
+		}
+		time.Sleep(pollInterval)
+	}
+}
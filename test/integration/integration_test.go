@@ -0,0 +1,57 @@
+// Copyright 2017 Steffen Gebert
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integration
+
+import (
+	"flag"
+	"testing"
+)
+
+var (
+	manifest = flag.String("manifest", "../../examples/flannel-network.yml", "FlannelNetwork to apply.")
+	keep     = flag.Bool("keep", false, "Keep the logs and data of etcd and kube-apiserver, even on success.")
+)
+
+func TestIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts etcd and a kube-apiserver")
+	}
+	for _, bin := range []struct{ env, name string }{
+		{etcdBinaryEnv, "etcd"},
+		{apiserverBinaryEnv, "kube-apiserver"},
+	} {
+		if _, err := binary(bin.env, bin.name); err != nil {
+			t.Skip(err)
+		}
+	}
+
+	cp, err := StartControlPlane()
+	if err != nil {
+		cp.Stop(true)
+		t.Fatalf("start control plane: %s", err)
+	}
+
+	suite, err := NewSuite(cp)
+	if err != nil {
+		cp.Stop(*keep)
+		t.Fatalf("create suite: %s", err)
+	}
+
+	if err := suite.Run(*manifest); err != nil {
+		cp.Stop(true)
+		t.Fatal(err)
+	}
+	cp.Stop(*keep)
+}
@@ -0,0 +1,274 @@
+// Copyright 2017 Steffen Gebert
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integration
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	etcd "github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+
+	"github.com/StephenKing/flannel-operator/pkg/client/flannelnetwork/v1alpha1"
+	"github.com/StephenKing/flannel-operator/pkg/flannel"
+
+	"k8s.io/client-go/1.5/kubernetes"
+	"k8s.io/client-go/1.5/pkg/api"
+	"k8s.io/client-go/1.5/pkg/api/errors"
+	"k8s.io/client-go/1.5/pkg/api/v1"
+	"k8s.io/client-go/1.5/pkg/labels"
+	"k8s.io/client-go/1.5/pkg/util/yaml"
+)
+
+const (
+	kubeSystemNamespace = "kube-system"
+	defaultNamespace    = "default"
+
+	// objectTimeout is how long the operator gets to converge on a change.
+	// It includes the registration of the CRDs on first use.
+	objectTimeout = 60 * time.Second
+)
+
+// Suite runs the operator against a control plane.
+type Suite struct {
+	cp      *ControlPlane
+	kclient *kubernetes.Clientset
+	fclient *v1alpha1.FlannelNetworkV1alpha1Client
+	etcd    etcd.KeysAPI
+}
+
+// NewSuite creates the clients of the suite.
+func NewSuite(cp *ControlPlane) (*Suite, error) {
+	kclient, err := kubernetes.NewForConfig(cp.RESTConfig())
+	if err != nil {
+		return nil, err
+	}
+	fclient, err := v1alpha1.NewForConfig(cp.RESTConfig())
+	if err != nil {
+		return nil, err
+	}
+	ec, err := etcd.New(etcd.Config{Endpoints: []string{cp.EtcdURL}})
+	if err != nil {
+		return nil, err
+	}
+	return &Suite{
+		cp:      cp,
+		kclient: kclient,
+		fclient: fclient,
+		etcd:    etcd.NewKeysAPI(ec),
+	}, nil
+}
+
+// Run starts the operator, applies the FlannelNetwork in manifestPath and
+// checks that the operator creates and removes its objects, then stops the
+// operator again.
+func (s *Suite) Run(manifestPath string) error {
+	flan, err := readFlannelNetwork(manifestPath)
+	if err != nil {
+		return err
+	}
+	if flan.Namespace == "" {
+		flan.Namespace = defaultNamespace
+	}
+
+	if err := s.ensureNamespace(kubeSystemNamespace); err != nil {
+		return err
+	}
+
+	op, err := flannel.New(s.cp.RESTConfig(), flannel.Config{
+		IPMasq:        true,
+		EtcdEndpoints: []string{s.cp.EtcdURL},
+	})
+	if err != nil {
+		return fmt.Errorf("create operator: %s", err)
+	}
+	stopc := make(chan struct{})
+	runErr := make(chan error, 1)
+	go func() { runErr <- op.Run(stopc) }()
+
+	steps := []struct {
+		name string
+		fn   func() error
+	}{
+		{"flannel-server DaemonSet is created", func() error {
+			return s.waitForDaemonSet("flannel-server", true)
+		}},
+		{"CNI installer DaemonSet is created", func() error {
+			return s.waitForDaemonSet("flannel-cni-installer", true)
+		}},
+		{"FlannelNetwork can be created", func() error {
+			return s.createFlannelNetwork(flan)
+		}},
+		{"client Deployment is created", func() error {
+			return s.waitForClientDeployment(flan, true)
+		}},
+		{"status is reported", func() error {
+			return s.waitForStatus(flan)
+		}},
+		{"network config is written to etcd", func() error {
+			return s.waitForEtcdKey("/coreos.com/network/"+flan.Spec.VNI+"/config", true)
+		}},
+		{"FlannelNetwork is stored in etcd", func() error {
+			return s.waitForEtcdKey(registryKey(flan), true)
+		}},
+		{"FlannelNetwork can be deleted", func() error {
+			return s.fclient.FlannelNetworks(flan.Namespace).Delete(flan.Name, nil)
+		}},
+		{"FlannelNetwork and its status are gone", func() error {
+			return s.waitForFlannelNetwork(flan, false)
+		}},
+		{"FlannelNetwork is deleted from etcd", func() error {
+			return s.waitForEtcdKey(registryKey(flan), false)
+		}},
+		{"client Deployment is deleted", func() error {
+			return s.waitForClientDeployment(flan, false)
+		}},
+		{"network config is deleted from etcd", func() error {
+			return s.waitForEtcdKey("/coreos.com/network/"+flan.Spec.VNI, false)
+		}},
+		{"flannel-server DaemonSet stays", func() error {
+			return s.waitForDaemonSet("flannel-server", true)
+		}},
+	}
+
+	var failed error
+	for _, step := range steps {
+		if err := step.fn(); err != nil {
+			log.Error("FAIL:", step.name+":", err)
+			failed = fmt.Errorf("%s: %s", step.name, err)
+			break
+		}
+		log.Notice("PASS:", step.name)
+	}
+
+	close(stopc)
+	if err := <-runErr; err != nil && failed == nil {
+		failed = fmt.Errorf("operator did not stop cleanly: %s", err)
+	}
+	return failed
+}
+
+func readFlannelNetwork(path string) (*v1alpha1.FlannelNetwork, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var flan v1alpha1.FlannelNetwork
+	if err := yaml.NewYAMLOrJSONDecoder(f, 4096).Decode(&flan); err != nil {
+		return nil, fmt.Errorf("decode %s: %s", path, err)
+	}
+	return &flan, nil
+}
+
+func (s *Suite) ensureNamespace(name string) error {
+	_, err := s.kclient.Core().Namespaces().Create(&v1.Namespace{
+		ObjectMeta: v1.ObjectMeta{Name: name},
+	})
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("create namespace %s: %s", name, err)
+	}
+	return nil
+}
+
+// createFlannelNetwork retries until the operator has registered the CRD.
+func (s *Suite) createFlannelNetwork(flan *v1alpha1.FlannelNetwork) error {
+	var lastErr error
+	err := poll(objectTimeout, func() (bool, error) {
+		_, lastErr = s.fclient.FlannelNetworks(flan.Namespace).Create(flan)
+		return lastErr == nil, nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %s", err, lastErr)
+	}
+	return nil
+}
+
+func (s *Suite) waitForDaemonSet(name string, exists bool) error {
+	return poll(objectTimeout, func() (bool, error) {
+		_, err := s.kclient.Extensions().DaemonSets(kubeSystemNamespace).Get(name)
+		return present(err, exists)
+	})
+}
+
+func (s *Suite) waitForClientDeployment(flan *v1alpha1.FlannelNetwork, exists bool) error {
+	selector := labels.SelectorFromSet(map[string]string{
+		"app": "flannel-client",
+		"vni": flan.Spec.VNI,
+	})
+	return poll(objectTimeout, func() (bool, error) {
+		depls, err := s.kclient.Extensions().Deployments(kubeSystemNamespace).List(api.ListOptions{
+			LabelSelector: selector,
+		})
+		if err != nil {
+			return false, err
+		}
+		return (len(depls.Items) > 0) == exists, nil
+	})
+}
+
+func (s *Suite) waitForStatus(flan *v1alpha1.FlannelNetwork) error {
+	return poll(objectTimeout, func() (bool, error) {
+		current, err := s.fclient.FlannelNetworks(flan.Namespace).Get(flan.Name)
+		if err != nil {
+			return false, err
+		}
+		return current.Status != nil && current.Status.Paused == flan.Spec.Paused, nil
+	})
+}
+
+// waitForFlannelNetwork waits until the FlannelNetwork, and with it its
+// status subresource, is there or gone.
+func (s *Suite) waitForFlannelNetwork(flan *v1alpha1.FlannelNetwork, exists bool) error {
+	return poll(objectTimeout, func() (bool, error) {
+		_, err := s.fclient.FlannelNetworks(flan.Namespace).Get(flan.Name)
+		return present(err, exists)
+	})
+}
+
+// registryKey is where the kube-apiserver keeps the FlannelNetwork in etcd.
+func registryKey(flan *v1alpha1.FlannelNetwork) string {
+	return "/registry/" + v1alpha1.TPRGroup + "/" + v1alpha1.TPRFlannelName + "/" + flan.Namespace + "/" + flan.Name
+}
+
+func (s *Suite) waitForEtcdKey(key string, exists bool) error {
+	return poll(objectTimeout, func() (bool, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		_, err := s.etcd.Get(ctx, key, nil)
+		if etcd.IsKeyNotFound(err) {
+			return !exists, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return exists, nil
+	})
+}
+
+// present tells from the error of a Get whether the object is in the
+// wanted state.
+func present(err error, exists bool) (bool, error) {
+	if errors.IsNotFound(err) {
+		return !exists, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}